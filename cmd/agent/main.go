@@ -3,8 +3,6 @@ package main
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
 	"fmt"
 	"log"
 	"net/http"
@@ -37,7 +35,10 @@ func main() {
 
 	StartServer()
 
-	respClient := client.NewClient()
+	respClient, err := client.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
 	runtimeStats := runtime.MemStats{}
 	requestValue := models.Metrics{}
 	var metricValues []models.Metrics
@@ -69,9 +70,12 @@ func main() {
 				go func() {
 					GetRuntimeStat(&runtimeStats)
 					metricValues = requestValue.SetMetrics(&runtimeStats)
-					metricsChan <- metricValues
-					metricsChan <- memMetricValues
-					metricsChan <- counter.SetPollCountMetricValue()
+					// все метрики отчёта отправляются вместе
+					report := make([]models.Metrics, 0, len(metricValues)+len(memMetricValues)+1)
+					report = append(report, metricValues...)
+					report = append(report, memMetricValues...)
+					report = append(report, counter.SetPollCountMetricValue()...)
+					metricsChan <- report
 				}()
 			}
 		}
@@ -80,48 +84,20 @@ func main() {
 	http.ListenAndServe(addr, nil)
 }
 
-func hash(m string, key []byte) (string, error) {
-	h := hmac.New(sha256.New, key)
-	_, err := h.Write([]byte(m))
-	return fmt.Sprintf("%x", h.Sum(nil)), err
-}
-
 func sendMetrics(jobs <-chan []models.Metrics, resp *client.Client) {
-	var hashValue string
-	var err error
 	for j := range jobs {
+		report := make([]models.Metrics, 0, len(j))
 		for _, metrics := range j {
 			if !metrics.MetricISEmpty() {
-				if resp.Config.Key != "" {
-					if metrics.Value != nil {
-						hashValue, err = hash(fmt.Sprintf("%s:gauge:%f", metrics.ID, *metrics.Value), []byte(resp.Config.Key))
-						if err != nil {
-							log.Fatal(err)
-						}
-						metrics.Hash = hashValue
-					} else if metrics.Delta != nil {
-						if resp.Config.Key != "" {
-							hashValue, err = hash(fmt.Sprintf("%s:counter:%d", metrics.ID, *metrics.Delta), []byte(resp.Config.Key))
-							if err != nil {
-								log.Fatal(err)
-							}
-							metrics.Hash = hashValue
-						}
-					}
-				}
-
-				log.Println("body: ", metrics)
-
-				err := resp.SendMetricByPath(metrics)
-				if err != nil {
-					log.Println("Err: ", err.Error())
-				}
-				err = resp.SendMetrics(metrics)
-				if err != nil {
-					log.Println("Err: ", err.Error())
-				}
+				// instance передаётся в заголовке X-Agent-ID, сервер добавляет метку после проверки хеша
+				report = append(report, resp.Sign(metrics))
 			}
 		}
+
+		log.Printf("send %d metrics, mode %s", len(report), resp.Config.SendMode)
+		if err := resp.Send(report); err != nil {
+			log.Println("Err: ", err.Error())
+		}
 	}
 }
 
@@ -5,14 +5,15 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"time"
+	"net"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-contrib/pprof"
-	"github.com/gin-gonic/gin"
+	flag "github.com/spf13/pflag"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
-	"github.com/iddanilov/metricsAndAlerting/internal/db"
 	"github.com/iddanilov/metricsAndAlerting/internal/server"
 )
 
@@ -31,63 +32,47 @@ func main() {
 
 	StartServer()
 
-	var useDB bool
-	var err error
-	storage := &db.DB{}
 	log.Println("create router")
 
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
-	defer cancel()
-
 	cfg := server.NewConfig()
-	file := server.NewStorages(cfg)
 
-	if cfg.DSN != "" {
-		storage, err = db.NewDB(cfg.DSN)
-		if err != nil {
-			log.Println(err)
-		}
-		err = storage.CreateTable(ctx)
-		if err != nil {
-			log.Println(err)
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		defer stop()
+		if err := migrate(ctx, cfg, args[1:]); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
 
-		useDB = true
+	initCtx, cancel := context.WithTimeout(context.Background(), cfg.DBConnectTimeout)
+	app, err := server.NewApp(initCtx, cfg)
+	cancel()
+	if err != nil {
+		log.Fatal(err)
 	}
-	log.Println(useDB)
-
-	reportIntervalTicker := time.NewTicker(cfg.StoreInterval)
-
-	go func(ctx context.Context) {
-		for {
-			<-reportIntervalTicker.C
-			log.Println("Write data in file")
-			err := file.SaveMetricInFile()
-			if err != nil {
-				log.Println(err)
-			}
-		}
 
-	}(ctx)
-	r := gin.New()
+	r := app.Router()
 
 	ginSwagger.WrapHandler(swaggerfiles.Handler,
 		ginSwagger.URL("http://localhost:8080/swagger/doc.json"),
 		ginSwagger.DefaultModelsExpandDepth(-1))
 
 	pprof.Register(r)
-
-	r.RedirectTrailingSlash = false
-
-	rg := server.NewRouterGroup(&r.RouterGroup, file, cfg.Key, storage, useDB)
-
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
-
-	rg.Routes()
 	pprof.RouteRegister(&r.RouterGroup, "pprof")
 
-	r.Run(cfg.Address)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer stop()
+
+	ln, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = app.Serve(ctx, ln); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
 }
 
 func StartServer() {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/db"
+	"github.com/iddanilov/metricsAndAlerting/internal/server"
+	"github.com/iddanilov/metricsAndAlerting/internal/sqlite"
+)
+
+const migrateUsage = `usage: server migrate [up | down [version] | to <version> | status]
+  up              apply all migrations (default)
+  down [version]  roll back to the version, previous version by default
+  to <version>    migrate up or down to the version
+  status          show applied migrations`
+
+// migrate - "server migrate" subcommand, changes schema of DATABASE_DSN and exits
+func migrate(ctx context.Context, cfg *server.Config, args []string) error {
+	if cfg.DSN == "" {
+		return errors.New("migrate: DATABASE_DSN or -d is required")
+	}
+	if _, ok := sqlite.Path(cfg.DSN); ok {
+		return errors.New("migrate: schema of SQLite storage is created on start, migrations are for Postgres")
+	}
+	storage, err := db.NewDB(cfg.DSN, cfg.DBOptions())
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		return storage.Migrate(ctx, db.LatestVersion)
+	case "down":
+		status, err := storage.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		target := appliedVersion(status) - 1
+		if len(args) > 1 {
+			if target, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("migrate down: %w", err)
+			}
+		}
+		if target < 0 {
+			return errors.New("migrate down: no applied migrations")
+		}
+		// версия проверяется под блокировкой миграций: down никогда не применяет миграции вверх
+		if err = storage.MigrateDown(ctx, target); err != nil {
+			return fmt.Errorf("migrate down: %w", err)
+		}
+		return nil
+	case "to":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("migrate to: %w", err)
+		}
+		return storage.Migrate(ctx, target)
+	case "status":
+		status, err := storage.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			state := "pending"
+			if m.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, state)
+		}
+		return nil
+	}
+	return errors.New(migrateUsage)
+}
+
+func appliedVersion(status []db.MigrationStatus) int {
+	var version int
+	for _, m := range status {
+		if m.Applied && m.Version > version {
+			version = m.Version
+		}
+	}
+	return version
+}
@@ -4,12 +4,18 @@ package client
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/json"
 	goflag "flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/caarlos0/env/v6"
@@ -23,23 +29,49 @@ var (
 	PollInterval   = flag.DurationP("p", "p", 2*time.Second, "help message for flagname")
 	ReportInterval = flag.DurationP("r", "r", 10*time.Second, "help message for flagname")
 	Key            = flag.StringP("k", "k", "", "help message for KEY")
+	InstanceID     = flag.String("instance", "", "help message for InstanceID, hostname by default")
+	SendMode       = flag.String("mode", ModeBatch, "help message for SendMode: batch, json or path")
 )
 
+// Modes of sending metrics to the server
+const (
+	ModeBatch = "batch" // все метрики отчёта одним gzip запросом на /updates/
+	ModeJSON  = "json"  // каждая метрика отдельным запросом на /update/
+	ModePath  = "path"  // каждая метрика отдельным запросом на /update/:type/:name/:value
+)
+
+// StatusError - server responded with not successful status.
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d", e.URL, e.Code)
+}
+
 type Config struct {
 	Address        string        `env:"ADDRESS"`
 	ReportInterval time.Duration `env:"REPORT_INTERVAL"`
 	PollInterval   time.Duration `env:"POLL_INTERVAL"`
 	Key            string        `env:"KEY"`
+	InstanceID     string        `env:"INSTANCE_ID"`
+	SendMode       string        `env:"SEND_MODE"`
 }
 
 type Client struct {
 	HTTPClient *http.Client
 	Config     Config
+	// batchUnsupported - сервер не поддерживает /updates/, метрики отправляются по одной
+	batchUnsupported atomic.Bool
 }
 
-func NewClient() *Client {
+// NewClient - client with config from env and flags, unknown send mode is an error.
+func NewClient() (*Client, error) {
 	var cfg Config
-	err := env.Parse(&cfg)
+	if err := env.Parse(&cfg); err != nil {
+		return nil, err
+	}
 	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
 	flag.Parse()
 	if cfg.Address == "" {
@@ -57,16 +89,112 @@ func NewClient() *Client {
 	if *Key != "" {
 		cfg.Key = *Key
 	}
-
-	if err != nil {
-		return nil
+	if cfg.InstanceID == "" {
+		cfg.InstanceID = *InstanceID
+	}
+	if cfg.SendMode == "" {
+		cfg.SendMode = *SendMode
 	}
+	if cfg.InstanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "unknown"
+		}
+		cfg.InstanceID = hostname
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &Client{
 		Config: cfg,
 		HTTPClient: &http.Client{
 			Timeout: time.Minute,
 		},
+	}, nil
+}
+
+// Validate - check values which can't be sent to the server.
+func (cfg Config) Validate() error {
+	switch cfg.SendMode {
+	case ModeBatch, ModeJSON, ModePath:
+		return nil
 	}
+	return fmt.Errorf("unknown send mode %q, use %s, %s or %s", cfg.SendMode, ModeBatch, ModeJSON, ModePath)
+}
+
+// Sign - metric with hash of HashData with the key, metric isn't changed without the key.
+// Instance of the agent is sent in header and isn't signed, so servers without labels accept the hash.
+func (c *Client) Sign(m models.Metrics) models.Metrics {
+	if c.Config.Key == "" {
+		return m
+	}
+	h := hmac.New(sha256.New, []byte(c.Config.Key))
+	h.Write([]byte(m.HashData()))
+	m.Hash = fmt.Sprintf("%x", h.Sum(nil))
+	return m
+}
+
+// Send - send metrics of the report according to the send mode.
+// If the server doesn't support batch updates, client switches to json mode.
+func (c *Client) Send(metrics []models.Metrics) error {
+	switch c.Config.SendMode {
+	case ModePath:
+		return c.sendEach(metrics, c.SendMetricByPath)
+	case ModeJSON:
+		return c.sendEach(metrics, c.SendMetrics)
+	}
+	if !c.batchUnsupported.Load() {
+		err := c.SendBatch(metrics)
+		if !isBatchUnsupported(err) {
+			return err
+		}
+		log.Println("Server doesn't support batch updates, switch to json mode: ", err)
+		c.batchUnsupported.Store(true)
+	}
+	return c.sendEach(metrics, c.SendMetrics)
+}
+
+func (c *Client) sendEach(metrics []models.Metrics, send func(models.Metrics) error) error {
+	var lastErr error
+	for _, m := range metrics {
+		if err := send(m); err != nil {
+			log.Println("Err: ", err.Error())
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// isBatchUnsupported - old servers respond 404 or 405 on /updates/.
+func isBatchUnsupported(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	if !ok {
+		return false
+	}
+	return statusErr.Code == http.StatusNotFound || statusErr.Code == http.StatusMethodNotAllowed || statusErr.Code == http.StatusNotImplemented
+}
+
+// SendBatch - send all metrics in one gzip compressed json request on /updates/.
+func (c *Client) SendBatch(metrics []models.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(metrics); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/updates/", c.Config.Address), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	return c.sendRequest(req)
 }
 
 func (c *Client) SendMetricByPath(params models.Metrics) error {
@@ -107,11 +235,15 @@ func (c *Client) SendMetrics(metrics models.Metrics) error {
 }
 
 func (c *Client) sendRequest(req *http.Request) error {
+	req.Header.Set(models.AgentHeader, c.Config.InstanceID)
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &StatusError{URL: req.URL.String(), Code: resp.StatusCode}
+	}
 
 	return nil
 
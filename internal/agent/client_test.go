@@ -0,0 +1,138 @@
+package client
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func testMetrics() []models.Metrics {
+	value := 1.5
+	delta := int64(3)
+	return []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	var got []models.Metrics
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// обработчик работает не в горутине теста: require здесь нельзя
+		atomic.AddInt32(&requests, 1)
+		assert.Equal(t, "/updates/", r.URL.Path)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(r.Body)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		assert.NoError(t, json.NewDecoder(gz).Decode(&got))
+	}))
+	defer srv.Close()
+
+	c := &Client{HTTPClient: srv.Client(), Config: Config{Address: srv.URL, SendMode: ModeBatch}}
+	require.NoError(t, c.Send(testMetrics()))
+	assert.Equal(t, int32(1), requests)
+	assert.Equal(t, testMetrics(), got)
+}
+
+func TestSendBatchFallback(t *testing.T) {
+	var batch, single int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/updates/":
+			atomic.AddInt32(&batch, 1)
+			w.WriteHeader(http.StatusNotFound)
+		case "/update/":
+			atomic.AddInt32(&single, 1)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{HTTPClient: srv.Client(), Config: Config{Address: srv.URL, SendMode: ModeBatch}}
+	require.NoError(t, c.Send(testMetrics()))
+	require.NoError(t, c.Send(testMetrics()))
+	// после первого отказа сервер больше не получает batch запросы
+	assert.Equal(t, int32(1), batch)
+	assert.Equal(t, int32(4), single)
+}
+
+func TestSignForOldServer(t *testing.T) {
+	const key = "secret"
+	// oldHash - хеш сервера без меток: id:type:value
+	oldHash := func(m models.Metrics) string {
+		var data string
+		if m.MType == "gauge" {
+			data = fmt.Sprintf("%s:gauge:%f", m.ID, *m.Value)
+		} else {
+			data = fmt.Sprintf("%s:counter:%d", m.ID, *m.Delta)
+		}
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write([]byte(data))
+		return fmt.Sprintf("%x", h.Sum(nil))
+	}
+	var accepted int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m models.Metrics
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&m)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		assert.Empty(t, m.Labels, "instance is sent in header only")
+		assert.Equal(t, "host1", r.Header.Get(models.AgentHeader))
+		if m.Hash != oldHash(m) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(&accepted, 1)
+	}))
+	defer srv.Close()
+
+	c := &Client{HTTPClient: srv.Client(), Config: Config{Address: srv.URL, SendMode: ModeJSON, Key: key, InstanceID: "host1"}}
+	report := make([]models.Metrics, 0, 2)
+	for _, m := range testMetrics() {
+		report = append(report, c.Sign(m))
+	}
+	require.NoError(t, c.Send(report))
+	assert.Equal(t, int32(2), accepted)
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		wantErr bool
+	}{
+		{name: "[Positive] batch", mode: ModeBatch},
+		{name: "[Positive] json", mode: ModeJSON},
+		{name: "[Positive] path", mode: ModePath},
+		{name: "[Negative] Опечатка в режиме", mode: "jsn", wantErr: true},
+		{name: "[Negative] Режим в верхнем регистре", mode: "BATCH", wantErr: true},
+		{name: "[Negative] Пустой режим", mode: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Config{SendMode: tt.mode}.Validate()
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "unknown send mode")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package alerting
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// State - state of the alert.
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// resolvedRetention - how long resolved alert is shown in /alerts.
+const resolvedRetention = 15 * time.Minute
+
+// Alert - state of one rule for one metric.
+type Alert struct {
+	Rule       string        `json:"rule"`
+	MetricID   string        `json:"metric_id"`
+	MetricType string        `json:"metric_type"`
+	Labels     models.Labels `json:"labels,omitempty"`
+	Value      float64       `json:"value"`
+	Threshold  float64       `json:"threshold"`
+	Op         string        `json:"op"`
+	State      State         `json:"state"`
+	ActiveAt   time.Time     `json:"active_at"`
+	FiredAt    *time.Time    `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time    `json:"resolved_at,omitempty"`
+}
+
+// Source - storage with current metric values.
+type Source interface {
+	GetMetrics(ctx context.Context) ([]models.Metrics, error)
+}
+
+// Engine - evaluates rules against metrics from the source.
+type Engine struct {
+	rules    []Rule
+	source   Source
+	interval time.Duration
+	mutex    sync.RWMutex
+	alerts   map[string]*Alert
+	now      func() time.Time
+	notify   func(alerts []Alert)
+}
+
+// NewEngine - create new rule engine.
+func NewEngine(rules []Rule, source Source, interval time.Duration) *Engine {
+	return &Engine{
+		rules:    rules,
+		source:   source,
+		interval: interval,
+		alerts:   make(map[string]*Alert),
+		now:      time.Now,
+	}
+}
+
+// OnChange - set function which receives alerts changed state after each evaluation.
+func (e *Engine) OnChange(notify func(alerts []Alert)) {
+	e.notify = notify
+}
+
+// Run - evaluate rules every interval until ctx is done.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := e.Evaluate(ctx)
+			if err != nil {
+				log.Println("Can't evaluate alert rules: ", err)
+				continue
+			}
+			if len(changed) > 0 && e.notify != nil {
+				e.notify(changed)
+			}
+		}
+	}
+}
+
+// Evaluate - evaluate all rules once. Returns alerts which changed state.
+func (e *Engine) Evaluate(ctx context.Context) ([]Alert, error) {
+	metrics, err := e.source.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := e.now()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var changed []Alert
+	seen := make(map[string]struct{}, len(e.alerts))
+	for _, rule := range e.rules {
+		for _, m := range metrics {
+			if !rule.Match(m) {
+				continue
+			}
+			value, ok := rule.Value(m)
+			if !ok {
+				continue
+			}
+			key := alertKey(rule, m)
+			seen[key] = struct{}{}
+			if alert, ok := e.apply(key, rule, m, value, rule.Compare(value), now); ok {
+				changed = append(changed, alert)
+			}
+		}
+	}
+
+	// метрика пропала из хранилища - считаем, что условие не выполняется
+	for key, alert := range e.alerts {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		if alert.State == StateResolved {
+			if alert.ResolvedAt != nil && now.Sub(*alert.ResolvedAt) > resolvedRetention {
+				delete(e.alerts, key)
+			}
+			continue
+		}
+		if alert.State == StatePending {
+			delete(e.alerts, key)
+			continue
+		}
+		alert.State = StateResolved
+		resolvedAt := now
+		alert.ResolvedAt = &resolvedAt
+		changed = append(changed, *alert)
+	}
+
+	return changed, nil
+}
+
+// apply - move alert to the next state. Returns alert copy if state was changed.
+func (e *Engine) apply(key string, rule Rule, m models.Metrics, value float64, active bool, now time.Time) (Alert, bool) {
+	alert, ok := e.alerts[key]
+	if !active {
+		if !ok {
+			return Alert{}, false
+		}
+		switch alert.State {
+		case StatePending:
+			delete(e.alerts, key)
+		case StateFiring:
+			alert.Value = value
+			alert.State = StateResolved
+			resolvedAt := now
+			alert.ResolvedAt = &resolvedAt
+			return *alert, true
+		case StateResolved:
+			if alert.ResolvedAt != nil && now.Sub(*alert.ResolvedAt) > resolvedRetention {
+				delete(e.alerts, key)
+			}
+		}
+		return Alert{}, false
+	}
+
+	if !ok || alert.State == StateResolved {
+		alert = &Alert{
+			Rule:       rule.Name,
+			MetricID:   m.ID,
+			MetricType: rule.Type,
+			Labels:     m.Labels.Copy(),
+			Threshold:  rule.Threshold,
+			Op:         rule.Op,
+			State:      StatePending,
+			ActiveAt:   now,
+		}
+		e.alerts[key] = alert
+	}
+	alert.Value = value
+
+	if alert.State == StatePending && now.Sub(alert.ActiveAt) >= time.Duration(rule.For) {
+		alert.State = StateFiring
+		firedAt := now
+		alert.FiredAt = &firedAt
+		return *alert, true
+	}
+	if alert.State == StatePending && alert.ActiveAt.Equal(now) {
+		return *alert, true
+	}
+	return Alert{}, false
+}
+
+// Alerts - copy of all known alerts sorted by rule and metric.
+func (e *Engine) Alerts() []Alert {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	result := make([]Alert, 0, len(e.alerts))
+	for _, alert := range e.alerts {
+		result = append(result, *alert)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Rule != result[j].Rule {
+			return result[i].Rule < result[j].Rule
+		}
+		return result[i].key() < result[j].key()
+	})
+	return result
+}
+
+// Rules - loaded rules.
+func (e *Engine) Rules() []Rule {
+	result := make([]Rule, len(e.rules))
+	copy(result, e.rules)
+	return result
+}
+
+func alertKey(rule Rule, m models.Metrics) string {
+	return rule.Name + "/" + m.Key()
+}
+
+func (a Alert) key() string {
+	return models.Metrics{ID: a.MetricID, Labels: a.Labels}.Key()
+}
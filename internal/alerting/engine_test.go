@@ -0,0 +1,145 @@
+package alerting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+type staticSource struct {
+	metrics []models.Metrics
+}
+
+func (s *staticSource) GetMetrics(ctx context.Context) ([]models.Metrics, error) {
+	return s.metrics, nil
+}
+
+func gauge(id string, value float64) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &value}
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	source := &staticSource{}
+	rule := Rule{Name: "HighAlloc", Metric: "Alloc", Type: "gauge", Op: ">", Threshold: 10, For: Duration(time.Minute)}
+	engine := NewEngine([]Rule{rule}, source, time.Second)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+
+	steps := []struct {
+		name    string
+		value   float64
+		after   time.Duration
+		state   State
+		changed bool
+	}{
+		{name: "[Positive] Значение ниже порога - алерта нет", value: 5, state: ""},
+		{name: "[Positive] Значение выше порога - алерт в pending", value: 15, after: time.Second, state: StatePending, changed: true},
+		{name: "[Positive] Время for не прошло - алерт остаётся в pending", value: 20, after: 30 * time.Second, state: StatePending},
+		{name: "[Positive] Время for прошло - алерт в firing", value: 20, after: 30 * time.Second, state: StateFiring, changed: true},
+		{name: "[Positive] Значение вернулось ниже порога - алерт resolved", value: 1, after: time.Second, state: StateResolved, changed: true},
+		{name: "[Positive] Значение снова выше порога - новый pending", value: 11, after: time.Second, state: StatePending, changed: true},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			now = now.Add(step.after)
+			source.metrics = []models.Metrics{gauge("Alloc", step.value)}
+			changed, err := engine.Evaluate(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, step.changed, len(changed) == 1)
+
+			alerts := engine.Alerts()
+			if step.state == "" {
+				assert.Empty(t, alerts)
+				return
+			}
+			require.Len(t, alerts, 1)
+			assert.Equal(t, step.state, alerts[0].State)
+			assert.Equal(t, step.value, alerts[0].Value)
+		})
+	}
+}
+
+func TestEngineCounterRule(t *testing.T) {
+	delta := int64(100)
+	source := &staticSource{metrics: []models.Metrics{
+		{ID: "PollCount", MType: "Counter", Delta: &delta},
+		gauge("PollCount", 1000),
+	}}
+	rule := Rule{Name: "TooManyPolls", Metric: "PollCount", Type: "counter", Op: ">=", Threshold: 100}
+	engine := NewEngine([]Rule{rule}, source, time.Second)
+
+	changed, err := engine.Evaluate(context.Background())
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateFiring, changed[0].State)
+	assert.Equal(t, float64(100), changed[0].Value)
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "[Positive] Корректный файл правил",
+			content: `{"rules":[{"name":"HighAlloc","metric":"Alloc","type":"Gauge","op":">","threshold":10,"for":"5m"}]}`,
+		},
+		{
+			name:    "[Negative] Неизвестный оператор",
+			content: `{"rules":[{"name":"HighAlloc","metric":"Alloc","type":"gauge","op":"~","threshold":10}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "[Negative] Неизвестный тип метрики",
+			content: `{"rules":[{"name":"HighAlloc","metric":"Alloc","type":"histogram","op":">","threshold":10}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "[Negative] Повторяющееся имя правила",
+			content: `{"rules":[{"name":"A","metric":"Alloc","type":"gauge","op":">"},{"name":"A","metric":"Sys","type":"gauge","op":">"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "rules.json")
+			require.NoError(t, os.WriteFile(fileName, []byte(tt.content), 0644))
+			rules, err := LoadRules(fileName)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, rules, 1)
+			assert.Equal(t, "gauge", rules[0].Type)
+			assert.Equal(t, Duration(5*time.Minute), rules[0].For)
+		})
+	}
+}
+
+func TestEngineLabels(t *testing.T) {
+	h1, h2, h3 := gauge("Alloc", 20), gauge("Alloc", 30), gauge("Alloc", 40)
+	h1.Labels = models.Labels{"host": "h1", "env": "prod"}
+	h2.Labels = models.Labels{"host": "h2", "env": "prod"}
+	h3.Labels = models.Labels{"host": "h3", "env": "dev"}
+	source := &staticSource{metrics: []models.Metrics{h1, h2, h3}}
+	rule := Rule{Name: "HighAlloc", Metric: "Alloc", Type: "gauge", Op: ">", Threshold: 10, Selector: `{env="prod"}`}
+	engine := NewEngine([]Rule{rule}, source, time.Second)
+
+	changed, err := engine.Evaluate(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, changed, 2)
+
+	alerts := engine.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, models.Labels{"host": "h1", "env": "prod"}, alerts[0].Labels)
+	assert.Equal(t, models.Labels{"host": "h2", "env": "prod"}, alerts[1].Labels)
+}
@@ -0,0 +1,302 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+const (
+	defaultRetries    = 3
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 30 * time.Second
+	defaultTimeout    = 10 * time.Second
+	queueSize         = 100
+)
+
+// Notifier - channel for alert notifications.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Notification - payload sent to notification channel.
+type Notification struct {
+	MetricID   string        `json:"metric_id"`
+	MetricType string        `json:"metric_type"`
+	Labels     models.Labels `json:"labels,omitempty"`
+	Value      float64       `json:"value"`
+	Rule       string        `json:"rule"`
+	Op         string        `json:"op"`
+	Threshold  float64       `json:"threshold"`
+	State      State         `json:"state"`
+	ActiveAt   time.Time     `json:"active_at"`
+	FiredAt    *time.Time    `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time    `json:"resolved_at,omitempty"`
+}
+
+// NewNotification - create notification payload for alert.
+func NewNotification(alert Alert) Notification {
+	return Notification{
+		MetricID:   alert.MetricID,
+		MetricType: alert.MetricType,
+		Labels:     alert.Labels,
+		Value:      alert.Value,
+		Rule:       alert.Rule,
+		Op:         alert.Op,
+		Threshold:  alert.Threshold,
+		State:      alert.State,
+		ActiveAt:   alert.ActiveAt,
+		FiredAt:    alert.FiredAt,
+		ResolvedAt: alert.ResolvedAt,
+	}
+}
+
+// ChannelConfig - notification channel description in the rules file.
+type ChannelConfig struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"` // webhook
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Timeout    Duration          `json:"timeout,omitempty"`
+	Retries    *int              `json:"retries,omitempty"`     // количество повторов после первой попытки
+	Backoff    Duration          `json:"backoff,omitempty"`     // начальная задержка между повторами
+	MaxBackoff Duration          `json:"max_backoff,omitempty"` // максимальная задержка между повторами
+	RateLimit  Duration          `json:"rate_limit,omitempty"`  // минимальный интервал между уведомлениями
+	Burst      int               `json:"burst,omitempty"`       // сколько уведомлений можно отправить без ожидания
+}
+
+// NewNotifier - create notifier from channel config.
+func NewNotifier(cfg ChannelConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "webhook", "":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("channel %s: url is empty", cfg.Name)
+		}
+		return NewWebhookNotifier(cfg), nil
+	default:
+		return nil, fmt.Errorf("channel %s: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// WebhookNotifier - sends alert as json POST request.
+type WebhookNotifier struct {
+	name       string
+	url        string
+	headers    map[string]string
+	client     *http.Client
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	limiter    *rateLimiter
+}
+
+// NewWebhookNotifier - create webhook notifier. Empty config values are replaced with defaults.
+func NewWebhookNotifier(cfg ChannelConfig) *WebhookNotifier {
+	n := &WebhookNotifier{
+		name:       cfg.Name,
+		url:        cfg.URL,
+		headers:    cfg.Headers,
+		client:     &http.Client{Timeout: defaultTimeout},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+		limiter:    newRateLimiter(time.Duration(cfg.RateLimit), cfg.Burst),
+	}
+	if n.name == "" {
+		n.name = cfg.URL
+	}
+	if cfg.Timeout > 0 {
+		n.client.Timeout = time.Duration(cfg.Timeout)
+	}
+	if cfg.Retries != nil {
+		n.retries = *cfg.Retries
+	}
+	if cfg.Backoff > 0 {
+		n.backoff = time.Duration(cfg.Backoff)
+	}
+	if cfg.MaxBackoff > 0 {
+		n.maxBackoff = time.Duration(cfg.MaxBackoff)
+	}
+	return n
+}
+
+func (n *WebhookNotifier) Name() string {
+	return n.name
+}
+
+// Notify - send alert. Waits for rate limiter and retries with exponential backoff
+// on network errors, 429 and 5xx responses.
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(NewNotification(alert))
+	if err != nil {
+		return err
+	}
+	if err = n.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	backoff := n.backoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = n.send(ctx, body)
+		if err == nil || !retry || attempt >= n.retries {
+			return err
+		}
+		log.Printf("Notifier %s: attempt %d failed: %v", n.name, attempt+1, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > n.maxBackoff {
+			backoff = n.maxBackoff
+		}
+	}
+}
+
+// send - make one request. Returns true if request can be retried.
+func (n *WebhookNotifier) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook %s responded with status %d", n.url, resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// rateLimiter - token bucket. Zero interval means no limit.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// reserve - take token and return how long caller should wait before use it.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.interval <= 0 {
+		return 0
+	}
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens * float64(l.interval))
+}
+
+// Wait - block until notification can be sent.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	wait := l.reserve(time.Now())
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Dispatcher - delivers changed alerts to all notifiers.
+// Every notifier has own queue, so slow channel doesn't block others.
+type Dispatcher struct {
+	notifiers []Notifier
+	queues    []chan Alert
+}
+
+// NewDispatcher - create dispatcher for notifiers.
+func NewDispatcher(notifiers []Notifier) *Dispatcher {
+	queues := make([]chan Alert, len(notifiers))
+	for i := range queues {
+		queues[i] = make(chan Alert, queueSize)
+	}
+	return &Dispatcher{
+		notifiers: notifiers,
+		queues:    queues,
+	}
+}
+
+// Dispatch - put firing and resolved alerts in notifiers queues.
+// Alert is dropped if queue of the channel is full.
+func (d *Dispatcher) Dispatch(alerts []Alert) {
+	for _, alert := range alerts {
+		if alert.State != StateFiring && alert.State != StateResolved {
+			continue
+		}
+		for i, queue := range d.queues {
+			select {
+			case queue <- alert:
+			default:
+				log.Printf("Notifier %s: queue is full, alert %s dropped", d.notifiers[i].Name(), alert.Rule)
+			}
+		}
+	}
+}
+
+// Run - send queued alerts until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range d.notifiers {
+		wg.Add(1)
+		go func(n Notifier, queue <-chan Alert) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case alert := <-queue:
+					err := n.Notify(ctx, alert)
+					if err != nil && !errors.Is(err, context.Canceled) {
+						log.Printf("Notifier %s: can't send alert %s: %v", n.Name(), alert.Rule, err)
+					}
+				}
+			}
+		}(d.notifiers[i], d.queues[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,153 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func firingAlert() Alert {
+	firedAt := time.Date(2023, 1, 1, 0, 1, 0, 0, time.UTC)
+	return Alert{
+		Rule:       "HighAlloc",
+		MetricID:   "Alloc",
+		MetricType: "gauge",
+		Value:      15,
+		Threshold:  10,
+		Op:         ">",
+		State:      StateFiring,
+		ActiveAt:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		FiredAt:    &firedAt,
+	}
+}
+
+func TestWebhookNotifierPayload(t *testing.T) {
+	var got Notification
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	n := NewWebhookNotifier(ChannelConfig{URL: receiver.URL, Headers: map[string]string{"X-Token": "secret"}})
+	require.NoError(t, n.Notify(context.Background(), firingAlert()))
+
+	assert.Equal(t, "Alloc", got.MetricID)
+	assert.Equal(t, "gauge", got.MetricType)
+	assert.Equal(t, float64(15), got.Value)
+	assert.Equal(t, "HighAlloc", got.Rule)
+	assert.Equal(t, StateFiring, got.State)
+}
+
+func TestWebhookNotifierRetry(t *testing.T) {
+	retries := 2
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		calls    int32
+	}{
+		{
+			name:     "[Positive] Сервер ответил 500, затем 200 - уведомление доставлено",
+			statuses: []int{http.StatusInternalServerError, http.StatusOK},
+			calls:    2,
+		},
+		{
+			name:     "[Negative] Сервер всегда отвечает 503 - ошибка после всех повторов",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			wantErr:  true,
+			calls:    3,
+		},
+		{
+			name:     "[Negative] Сервер ответил 400 - повтор не выполняется",
+			statuses: []int{http.StatusBadRequest, http.StatusOK},
+			wantErr:  true,
+			calls:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.statuses[call-1])
+			}))
+			defer receiver.Close()
+
+			n := NewWebhookNotifier(ChannelConfig{
+				URL:     receiver.URL,
+				Retries: &retries,
+				Backoff: Duration(time.Millisecond),
+			})
+			err := n.Notify(context.Background(), firingAlert())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.calls, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestWebhookNotifierRateLimit(t *testing.T) {
+	var mutex sync.Mutex
+	var times []time.Time
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		times = append(times, time.Now())
+		mutex.Unlock()
+	}))
+	defer receiver.Close()
+
+	interval := 50 * time.Millisecond
+	n := NewWebhookNotifier(ChannelConfig{URL: receiver.URL, RateLimit: Duration(interval)})
+	for i := 0; i < 3; i++ {
+		require.NoError(t, n.Notify(context.Background(), firingAlert()))
+	}
+
+	require.Len(t, times, 3)
+	assert.GreaterOrEqual(t, times[2].Sub(times[0]), 2*interval-5*time.Millisecond)
+}
+
+func TestDispatcher(t *testing.T) {
+	received := make(chan Notification, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		received <- n
+	}))
+	defer receiver.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewDispatcher([]Notifier{NewWebhookNotifier(ChannelConfig{URL: receiver.URL})})
+	go d.Run(ctx)
+
+	pending := firingAlert()
+	pending.State = StatePending
+	d.Dispatch([]Alert{pending, firingAlert()})
+
+	select {
+	case n := <-received:
+		assert.Equal(t, StateFiring, n.State)
+	case <-time.After(time.Second):
+		t.Fatal("notification wasn't delivered")
+	}
+	select {
+	case n := <-received:
+		t.Fatalf("unexpected notification %v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,178 @@
+// Package alerting - rule engine evaluated against stored metrics.
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// Duration - time.Duration which is read from json as "30s", "5m" etc.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(time.Duration(v) * time.Second)
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %s", string(b))
+	}
+	return nil
+}
+
+// Rule - threshold condition for one metric.
+// For gauge metric compared Value, for counter metric compared Delta.
+type Rule struct {
+	Name      string   `json:"name"`
+	Metric    string   `json:"metric"`          // имя метрики
+	Type      string   `json:"type"`            // gauge или counter
+	Op        string   `json:"op"`              // оператор сравнения: >, >=, <, <=, ==, !=
+	Threshold float64  `json:"threshold"`       // пороговое значение
+	For       Duration `json:"for"`             // сколько условие должно выполняться до firing
+	Selector  string   `json:"match,omitempty"` // фильтр серий по меткам, например {host="h1"}
+
+	matchers []models.Matcher
+}
+
+// RulesFile - structure of the rules file.
+type RulesFile struct {
+	Rules    []Rule          `json:"rules"`
+	Channels []ChannelConfig `json:"channels"`
+}
+
+// LoadRules - read and validate rules from json file.
+func LoadRules(fileName string) ([]Rule, error) {
+	file, err := LoadRulesFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return file.Rules, nil
+}
+
+// Notifiers - create notifiers for all channels of the file.
+func (f *RulesFile) Notifiers() ([]Notifier, error) {
+	result := make([]Notifier, 0, len(f.Channels))
+	for _, channel := range f.Channels {
+		n, err := NewNotifier(channel)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// LoadRulesFile - read and validate rules and notification channels from json file.
+func LoadRulesFile(fileName string) (*RulesFile, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var file RulesFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("can't parse rules file %s: %w", fileName, err)
+	}
+	names := make(map[string]struct{}, len(file.Rules))
+	for i, rule := range file.Rules {
+		if err = rule.Validate(); err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i, err)
+		}
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("rule #%d: duplicate rule name %s", i, rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		file.Rules[i].Type = strings.ToLower(rule.Type)
+		file.Rules[i].matchers, _ = models.ParseMatchers(rule.Selector)
+	}
+	return &file, nil
+}
+
+// Validate - check that rule can be evaluated.
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule name is empty")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("rule %s: metric is empty", r.Name)
+	}
+	switch strings.ToLower(r.Type) {
+	case "gauge", "counter":
+	default:
+		return fmt.Errorf("rule %s: unknown metric type %q", r.Name, r.Type)
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("rule %s: unknown operator %q", r.Name, r.Op)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("rule %s: negative for duration", r.Name)
+	}
+	if _, err := models.ParseMatchers(r.Selector); err != nil {
+		return fmt.Errorf("rule %s: %w", r.Name, err)
+	}
+	return nil
+}
+
+// Match - check that rule is about this metric series.
+func (r Rule) Match(m models.Metrics) bool {
+	if m.ID != r.Metric || !strings.EqualFold(m.MType, r.Type) {
+		return false
+	}
+	if r.matchers == nil && r.Selector != "" {
+		r.matchers, _ = models.ParseMatchers(r.Selector)
+	}
+	return models.MatchLabels(m.Labels, r.matchers)
+}
+
+// Value - get compared value of metric.
+func (r Rule) Value(m models.Metrics) (float64, bool) {
+	if strings.EqualFold(r.Type, "gauge") {
+		if m.Value == nil {
+			return 0, false
+		}
+		return *m.Value, true
+	}
+	if m.Delta == nil {
+		return 0, false
+	}
+	return float64(*m.Delta), true
+}
+
+// Compare - check threshold condition for value.
+func (r Rule) Compare(value float64) bool {
+	switch r.Op {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	return false
+}
@@ -0,0 +1,309 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// defaultFlushInterval - flush interval of the write buffer if FlushInterval isn't set
+const defaultFlushInterval = time.Second
+
+// writeBuffer - single metric updates waiting for flush to the database.
+// One entry per series (type, name, labels): counters are summed, the last gauge wins.
+// New series wait while the buffer is full, updates of queued series never wait.
+type writeBuffer struct {
+	mutex   sync.Mutex
+	size    int
+	pending []models.Metrics
+	index   map[string]int
+	// drained - closed when queued updates are taken for flush
+	drained chan struct{}
+	// full - wakes up the flusher before the interval
+	full chan struct{}
+	// types - saved types of metric names, type of name never changes
+	types map[string]string
+
+	waits       uint64
+	flushes     uint64
+	flushErrors uint64
+	flushed     uint64
+	dropped     uint64
+	flushTime   time.Duration
+	lastFlush   time.Duration
+}
+
+func newWriteBuffer(size int) *writeBuffer {
+	return &writeBuffer{
+		size:    size,
+		pending: make([]models.Metrics, 0, size),
+		index:   make(map[string]int, size),
+		drained: make(chan struct{}),
+		full:    make(chan struct{}, 1),
+		types:   make(map[string]string),
+	}
+}
+
+// seriesKey - key of series in batch: type, name and labels
+func seriesKey(m models.Metrics) string {
+	return m.MType + ":" + m.Key()
+}
+
+// mergeInto - apply newer update of the same series: counter is summed, gauge is replaced
+func mergeInto(saved *models.Metrics, m models.Metrics) {
+	switch {
+	case m.MType == "counter" && m.Delta != nil:
+		var delta int64
+		if saved.Delta != nil {
+			delta = *saved.Delta
+		}
+		delta += *m.Delta
+		saved.Delta = &delta
+	case m.MType != "counter" && m.Value != nil:
+		saved.Value = m.Value
+	}
+}
+
+// add - queue update, wait for flush while the buffer is full or until ctx is done
+func (b *writeBuffer) add(ctx context.Context, m models.Metrics) error {
+	m.MType = strings.ToLower(m.MType)
+	key := seriesKey(m)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for {
+		if i, ok := b.index[key]; ok {
+			mergeInto(&b.pending[i], m)
+			return nil
+		}
+		if len(b.pending) < b.size {
+			b.index[key] = len(b.pending)
+			b.pending = append(b.pending, m)
+			if len(b.pending) >= b.size {
+				b.wakeFlusher()
+			}
+			return nil
+		}
+		// буфер заполнен: ждём, пока flusher заберёт обновления
+		b.waits++
+		b.wakeFlusher()
+		drained := b.drained
+		b.mutex.Unlock()
+		select {
+		case <-drained:
+			b.mutex.Lock()
+		case <-ctx.Done():
+			b.mutex.Lock()
+			return ctx.Err()
+		}
+	}
+}
+
+// savedType - cached type of metric name saved in the database
+func (b *writeBuffer) savedType(id string) (string, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	mType, ok := b.types[id]
+	return mType, ok
+}
+
+func (b *writeBuffer) saveType(id, mType string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.types[id] = mType
+}
+
+func (b *writeBuffer) wakeFlusher() {
+	select {
+	case b.full <- struct{}{}:
+	default:
+	}
+}
+
+// take - queued updates for flush, waiting writers are released
+func (b *writeBuffer) take() []models.Metrics {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	batch := b.pending
+	b.pending = make([]models.Metrics, 0, b.size)
+	b.index = make(map[string]int, b.size)
+	close(b.drained)
+	b.drained = make(chan struct{})
+	return batch
+}
+
+// putBack - return updates which aren't written, they are older than the queued ones
+func (b *writeBuffer) putBack(batch []models.Metrics) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	pending := mergeBatch(append(batch, b.pending...))
+	b.pending = pending
+	b.index = make(map[string]int, len(pending))
+	for i, m := range pending {
+		b.index[seriesKey(m)] = i
+	}
+}
+
+func (b *writeBuffer) depth() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.pending)
+}
+
+// record - save result of flush for statistics
+func (b *writeBuffer) record(duration time.Duration, written, dropped int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.flushes++
+	b.flushTime += duration
+	b.lastFlush = duration
+	b.flushed += uint64(written)
+	b.dropped += uint64(dropped)
+	if err != nil {
+		b.flushErrors++
+	}
+}
+
+func (b *writeBuffer) writeStats(w io.Writer) error {
+	b.mutex.Lock()
+	depth, waits, flushes, flushErrors := len(b.pending), b.waits, b.flushes, b.flushErrors
+	flushed, dropped, flushTime, lastFlush := b.flushed, b.dropped, b.flushTime, b.lastFlush
+	b.mutex.Unlock()
+
+	buf := bufio.NewWriter(w)
+	writeMetric(buf, "db_buffer_capacity", "gauge", "Maximum number of queued series.", float64(b.size))
+	writeMetric(buf, "db_buffer_queue_depth", "gauge", "Number of queued series.", float64(depth))
+	writeMetric(buf, "db_buffer_waits_total", "counter", "Total number of updates waited for a full buffer.", float64(waits))
+	writeMetric(buf, "db_buffer_flushed_total", "counter", "Total number of series written by flushes.", float64(flushed))
+	writeMetric(buf, "db_buffer_dropped_total", "counter", "Total number of series dropped by flushes.", float64(dropped))
+	writeMetric(buf, "db_buffer_flush_errors_total", "counter", "Total number of failed flushes.", float64(flushErrors))
+	writeMetric(buf, "db_buffer_last_flush_duration_seconds", "gauge", "Duration of the last flush.", lastFlush.Seconds())
+	fmt.Fprintf(buf, "# HELP db_buffer_flush_duration_seconds Duration of flushes.\n# TYPE db_buffer_flush_duration_seconds summary\n")
+	fmt.Fprintf(buf, "db_buffer_flush_duration_seconds_sum %v\ndb_buffer_flush_duration_seconds_count %v\n", flushTime.Seconds(), flushes)
+	return buf.Flush()
+}
+
+// bufferMetric - queue update of metric name saved with the same type.
+// Update of a new name is written at once, so its type is saved and a conflicting update
+// is rejected with middleware.ErrConflict instead of being dropped by flush.
+func (db *DB) bufferMetric(ctx context.Context, m models.Metrics) error {
+	saved, err := db.metricType(ctx, m.ID)
+	if err != nil {
+		return err
+	}
+	mType := strings.ToLower(m.MType)
+	switch {
+	case saved == "":
+		return db.UpdateMetrics(ctx, []models.Metrics{m})
+	case saved != mType:
+		return fmt.Errorf("%w: metric %s is %s, not %s", middleware.ErrConflict, m.ID, saved, mType)
+	}
+	return db.buffer.add(ctx, m)
+}
+
+// metricType - saved type of metric name, "" if the name isn't saved
+func (db *DB) metricType(ctx context.Context, id string) (string, error) {
+	if mType, ok := db.buffer.savedType(id); ok {
+		return mType, nil
+	}
+	var mType string
+	err := db.do(ctx, func(ctx context.Context) error {
+		return db.DB.QueryRowContext(ctx, queryGetMetricType, id).Scan(&mType)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	db.buffer.saveType(id, mType)
+	return mType, nil
+}
+
+// runFlusher - flush the buffer every interval and when it's full until Close
+func (db *DB) runFlusher(interval time.Duration) {
+	defer close(db.flusherDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stopFlusher:
+			return
+		case <-ticker.C:
+		case <-db.buffer.full:
+		}
+		if err := db.Flush(context.Background()); err != nil {
+			log.Println("Can't flush write buffer", err)
+		}
+	}
+}
+
+// Flush - write updates queued by UpdateMetric to the database.
+// Updates are returned to the buffer if the database is unavailable.
+// Series with type conflict and updates with unknown commit result are dropped.
+func (db *DB) Flush(ctx context.Context) error {
+	if db.buffer == nil {
+		return nil
+	}
+	db.flushMutex.Lock()
+	defer db.flushMutex.Unlock()
+
+	batch := db.buffer.take()
+	if len(batch) == 0 {
+		return nil
+	}
+	start := time.Now()
+	written, dropped, err := db.flushBatch(ctx, batch)
+	db.buffer.record(time.Since(start), written, dropped, err)
+	return err
+}
+
+// flushBatch - write batch, on type conflict write series one by one to skip conflicting ones
+func (db *DB) flushBatch(ctx context.Context, batch []models.Metrics) (written, dropped int, err error) {
+	err = db.writeMetrics(ctx, batch)
+	switch {
+	case err == nil:
+		return len(batch), 0, nil
+	case errors.Is(err, errUnknownCommit):
+		log.Printf("Write buffer: %d series may be lost: %v", len(batch), err)
+		return 0, len(batch), err
+	case !errors.Is(err, middleware.ErrConflict):
+		db.buffer.putBack(batch)
+		return 0, 0, err
+	}
+
+	for i, m := range batch {
+		err = db.writeMetrics(ctx, []models.Metrics{m})
+		switch {
+		case err == nil:
+			written++
+		case errors.Is(err, middleware.ErrConflict), errors.Is(err, errUnknownCommit):
+			log.Println("Write buffer: series is dropped:", err)
+			dropped++
+		default:
+			db.buffer.putBack(batch[i:])
+			return written, dropped, err
+		}
+	}
+	return written, dropped, nil
+}
+
+// flushPending - flush the buffer before read, so reader sees accepted updates
+func (db *DB) flushPending(ctx context.Context) {
+	if db.buffer == nil || db.buffer.depth() == 0 {
+		return
+	}
+	if err := db.Flush(ctx); err != nil {
+		log.Println("Can't flush write buffer", err)
+	}
+}
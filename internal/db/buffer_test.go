@@ -0,0 +1,120 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestWriteBuffer(t *testing.T) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+	ctx := context.Background()
+
+	t.Run("[Positive] Обновления одной серии объединяются", func(t *testing.T) {
+		b := newWriteBuffer(10)
+		first := delta(2)
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "PollCount", MType: "Counter", Delta: first}))
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(3)}))
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(1.5)}))
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(2.5)}))
+		assert.Equal(t, 2, b.depth())
+
+		batch := b.take()
+		assert.Equal(t, []models.Metrics{
+			{ID: "PollCount", MType: "counter", Delta: delta(5)},
+			{ID: "Alloc", MType: "gauge", Value: value(2.5)},
+		}, batch)
+		assert.Equal(t, int64(2), *first, "update must not be changed")
+		assert.Equal(t, 0, b.depth())
+	})
+
+	t.Run("[Positive] Полный буфер ждёт flush, обновление серии в очереди не ждёт", func(t *testing.T) {
+		b := newWriteBuffer(1)
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(1)}))
+		select {
+		case <-b.full:
+		default:
+			t.Fatal("flusher must be woken up when buffer is full")
+		}
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(2)}))
+
+		added := make(chan error, 1)
+		go func() {
+			added <- b.add(ctx, models.Metrics{ID: "HeapAlloc", MType: "gauge", Value: value(3)})
+		}()
+		select {
+		case <-added:
+			t.Fatal("new series must wait while buffer is full")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.Equal(t, []models.Metrics{{ID: "Alloc", MType: "gauge", Value: value(2)}}, b.take())
+		require.NoError(t, <-added)
+		assert.Equal(t, 1, b.depth())
+	})
+
+	t.Run("[Negative] Ожидание прерывается отменой запроса", func(t *testing.T) {
+		b := newWriteBuffer(1)
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(1)}))
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		err := b.add(timeout, models.Metrics{ID: "HeapAlloc", MType: "gauge", Value: value(3)})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, b.depth())
+	})
+
+	t.Run("[Positive] Незаписанные обновления возвращаются перед новыми", func(t *testing.T) {
+		b := newWriteBuffer(10)
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(2)}))
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(1)}))
+		batch := b.take()
+
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(5)}))
+		require.NoError(t, b.add(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(3)}))
+		b.putBack(batch)
+
+		assert.Equal(t, []models.Metrics{
+			{ID: "PollCount", MType: "counter", Delta: delta(5)},
+			{ID: "Alloc", MType: "gauge", Value: value(5)},
+		}, b.take(), "newer gauge wins, counters are summed")
+	})
+}
+
+func TestBufferMetricConflict(t *testing.T) {
+	ctx := context.Background()
+	value := 1.5
+	delta := int64(2)
+	db := &DB{buffer: newWriteBuffer(10)}
+	db.buffer.saveType("PollCount", "counter")
+
+	err := db.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: "gauge", Value: &value})
+	assert.ErrorIs(t, err, middleware.ErrConflict)
+	assert.Equal(t, 0, db.buffer.depth(), "conflicting update isn't queued")
+
+	require.NoError(t, db.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: "Counter", Delta: &delta}))
+	assert.Equal(t, 1, db.buffer.depth())
+}
+
+func TestWriteBufferStats(t *testing.T) {
+	b := newWriteBuffer(100)
+	value := 1.5
+	require.NoError(t, b.add(context.Background(), models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}))
+	b.record(250*time.Millisecond, 10, 1, nil)
+	b.record(250*time.Millisecond, 0, 0, context.DeadlineExceeded)
+
+	var buf bytes.Buffer
+	require.NoError(t, b.writeStats(&buf))
+	assert.Contains(t, buf.String(), "db_buffer_capacity 100\n")
+	assert.Contains(t, buf.String(), "db_buffer_queue_depth 1\n")
+	assert.Contains(t, buf.String(), "db_buffer_flushed_total 10\n")
+	assert.Contains(t, buf.String(), "db_buffer_dropped_total 1\n")
+	assert.Contains(t, buf.String(), "db_buffer_flush_errors_total 1\n")
+	assert.Contains(t, buf.String(), "db_buffer_flush_duration_seconds_sum 0.5\ndb_buffer_flush_duration_seconds_count 2\n")
+}
@@ -0,0 +1,305 @@
+package db
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// readCache - write-through cache of series by name and labels, least recently used series are evicted.
+//
+// Cached counter always equals the database: delta of every committed write is added to it,
+// concurrent writes of the same counter commute. Series loaded by a reader isn't cached if a write
+// of the series started during the load, because the loaded value may miss it or already contain it.
+// Gauge written concurrently by several writers is removed, the next read loads it from the database.
+type readCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+	// writing - series being written
+	writing map[string]*cacheWrite
+	// loading - reads of series from the database, write marks them stale
+	loading map[string][]*cacheLoad
+	// writes - number of started writes, for loads of unknown series
+	writes uint64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cacheLoad struct {
+	stale bool
+}
+
+type cacheWrite struct {
+	// count - number of started and not finished batches with the series
+	count int
+	// concurrent - batches with the series overlapped, order of their commits is unknown
+	concurrent bool
+}
+
+func newReadCache(size int) *readCache {
+	return &readCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+		writing: make(map[string]*cacheWrite),
+		loading: make(map[string][]*cacheLoad),
+	}
+}
+
+// cacheKey - series key: name and labels, type of series with the name is unique
+func cacheKey(id string, labels models.Labels) string {
+	return models.Metrics{ID: id, Labels: labels}.Key()
+}
+
+// copyMetric - metric which doesn't share values and labels with the cache
+func copyMetric(m models.Metrics) models.Metrics {
+	if m.Delta != nil {
+		delta := *m.Delta
+		m.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *m.Value
+		m.Value = &value
+	}
+	m.Labels = m.Labels.Copy()
+	return m
+}
+
+// get - cached series, mType "" matches any type
+func (c *readCache) get(mType, id string, labels models.Labels) (models.Metrics, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[cacheKey(id, labels)]
+	if !ok || (mType != "" && !strings.EqualFold(e.Value.(models.Metrics).MType, mType)) {
+		c.misses++
+		return models.Metrics{}, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	return copyMetric(e.Value.(models.Metrics)), true
+}
+
+// startLoad - register read of series from the database, nil if series is being written
+func (c *readCache) startLoad(key string) *cacheLoad {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.writing[key] != nil {
+		return nil
+	}
+	load := &cacheLoad{}
+	c.loading[key] = append(c.loading[key], load)
+	return load
+}
+
+// finishLoad - cache loaded series if no write started during the load
+func (c *readCache) finishLoad(key string, load *cacheLoad, m models.Metrics, loaded bool) {
+	if load == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	loads := c.loading[key]
+	for i, l := range loads {
+		if l == load {
+			loads = append(loads[:i], loads[i+1:]...)
+			break
+		}
+	}
+	if len(loads) == 0 {
+		delete(c.loading, key)
+	} else {
+		c.loading[key] = loads
+	}
+	if loaded && !load.stale {
+		c.set(key, copyMetric(m))
+	}
+}
+
+// startWrite - mark series of batch as written, loads in progress become stale
+func (c *readCache) startWrite(metrics []models.Metrics) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writes++
+	for key := range batchKeys(metrics) {
+		w, ok := c.writing[key]
+		if !ok {
+			w = &cacheWrite{}
+			c.writing[key] = w
+		}
+		w.count++
+		w.concurrent = w.concurrent || w.count > 1
+		for _, load := range c.loading[key] {
+			load.stale = true
+		}
+	}
+}
+
+func batchKeys(metrics []models.Metrics) map[string]struct{} {
+	keys := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		keys[m.Key()] = struct{}{}
+	}
+	return keys
+}
+
+// finishWrite - apply committed batch: delta is added to cached counter, gauge is replaced
+// if it wasn't written concurrently. After failed write the result is unknown and series are removed.
+func (c *readCache) finishWrite(metrics []models.Metrics, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	writes := make(map[string]*cacheWrite)
+	for key := range batchKeys(metrics) {
+		w := c.writing[key]
+		writes[key] = w
+		if w.count--; w.count == 0 {
+			delete(c.writing, key)
+		}
+	}
+	for _, m := range metrics {
+		key := m.Key()
+		e, cached := c.entries[key]
+		mType := strings.ToLower(m.MType)
+		switch {
+		case err != nil:
+			c.remove(key)
+		case cached && !strings.EqualFold(e.Value.(models.Metrics).MType, mType):
+			c.remove(key)
+		case mType == "counter":
+			if cached && m.Delta != nil {
+				saved := e.Value.(models.Metrics)
+				delta := *m.Delta
+				if saved.Delta != nil {
+					delta += *saved.Delta
+				}
+				saved.Delta = &delta
+				e.Value = saved
+			}
+		case writes[key].concurrent:
+			c.remove(key)
+		case m.Value != nil:
+			saved := copyMetric(m)
+			saved.MType = mType
+			saved.Hash = ""
+			if cached {
+				saved.Delta = e.Value.(models.Metrics).Delta
+			}
+			c.set(key, saved)
+		}
+	}
+}
+
+func (c *readCache) set(key string, m models.Metrics) {
+	if e, ok := c.entries[key]; ok {
+		e.Value = m
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(m)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(models.Metrics).Key())
+		c.evictions++
+	}
+}
+
+func (c *readCache) remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+// startBulkLoad - register read of unknown series, returns number of started writes
+func (c *readCache) startBulkLoad() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.writes
+}
+
+// finishBulkLoad - cache loaded series if no write was in progress or started during the load.
+// Returns number of cached series.
+func (c *readCache) finishBulkLoad(writes uint64, metrics []models.Metrics) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.writes != writes || len(c.writing) > 0 {
+		return 0
+	}
+	for _, m := range metrics {
+		c.set(m.Key(), copyMetric(m))
+	}
+	return len(metrics)
+}
+
+func (c *readCache) full() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len() >= c.size
+}
+
+func (c *readCache) writeStats(w io.Writer) error {
+	c.mutex.Lock()
+	entries, hits, misses, evictions := c.lru.Len(), c.hits, c.misses, c.evictions
+	c.mutex.Unlock()
+
+	buf := bufio.NewWriter(w)
+	writeMetric(buf, "db_cache_capacity", "gauge", "Maximum number of cached series.", float64(c.size))
+	writeMetric(buf, "db_cache_entries", "gauge", "Number of cached series.", float64(entries))
+	writeMetric(buf, "db_cache_hits_total", "counter", "Total number of reads served from the cache.", float64(hits))
+	writeMetric(buf, "db_cache_misses_total", "counter", "Total number of reads from the database.", float64(misses))
+	writeMetric(buf, "db_cache_evictions_total", "counter", "Total number of evicted series.", float64(evictions))
+	return buf.Flush()
+}
+
+// cachedMetric - series from the cache, on miss from the database by query; loaded series is cached
+func (db *DB) cachedMetric(ctx context.Context, mType, id string, labels models.Labels, query string, args ...interface{}) (models.Metrics, error) {
+	if db.cache == nil {
+		return db.queryMetric(ctx, query, args...)
+	}
+	db.flushPending(ctx)
+	if m, ok := db.cache.get(mType, id, labels); ok {
+		return m, nil
+	}
+	key := cacheKey(id, labels)
+	load := db.cache.startLoad(key)
+	m, err := db.queryMetric(ctx, query, args...)
+	db.cache.finishLoad(key, load, m, err == nil)
+	return m, err
+}
+
+// Warm - load series of metrics from GetMetricNames into the read cache until it's full
+func (db *DB) Warm(ctx context.Context) error {
+	if db.cache == nil {
+		return nil
+	}
+	names, err := db.GetMetricNames(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	var loaded int
+	for _, name := range names {
+		if db.cache.full() {
+			break
+		}
+		writes := db.cache.startBulkLoad()
+		series, err := db.queryMetrics(ctx, queryGetSeries, name)
+		if err != nil {
+			return err
+		}
+		loaded += db.cache.finishBulkLoad(writes, series)
+	}
+	log.Printf("Read cache is warmed with %d series", loaded)
+	return nil
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestReadCache(t *testing.T) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+	host := models.Labels{"host": "h1"}
+
+	// load - чтение серии из БД через кэш
+	load := func(c *readCache, m models.Metrics) {
+		key := m.Key()
+		c.finishLoad(key, c.startLoad(key), m, true)
+	}
+	write := func(c *readCache, m models.Metrics, err error) {
+		c.startWrite([]models.Metrics{m})
+		c.finishWrite([]models.Metrics{m}, err)
+	}
+
+	t.Run("[Positive] Загруженная серия читается из кэша по имени, меткам и типу", func(t *testing.T) {
+		c := newReadCache(10)
+		load(c, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(1.5), Labels: host})
+
+		m, ok := c.get("gauge", "Alloc", host)
+		require.True(t, ok)
+		assert.Equal(t, 1.5, *m.Value)
+		_, ok = c.get("", "Alloc", host)
+		assert.True(t, ok, "GetMetric doesn't check type")
+		_, ok = c.get("counter", "Alloc", host)
+		assert.False(t, ok)
+		_, ok = c.get("gauge", "Alloc", nil)
+		assert.False(t, ok, "series without labels isn't cached")
+
+		*m.Value = 100
+		m, _ = c.get("gauge", "Alloc", host)
+		assert.Equal(t, 1.5, *m.Value, "reader must not change cache")
+	})
+
+	t.Run("[Positive] Записанная дельта прибавляется к счётчику в кэше", func(t *testing.T) {
+		c := newReadCache(10)
+		load(c, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(5)})
+		write(c, models.Metrics{ID: "PollCount", MType: "Counter", Delta: delta(3)}, nil)
+
+		m, ok := c.get("counter", "PollCount", nil)
+		require.True(t, ok)
+		assert.Equal(t, int64(8), *m.Delta)
+	})
+
+	t.Run("[Positive] Неизвестный счётчик не кэшируется при записи, gauge кэшируется", func(t *testing.T) {
+		c := newReadCache(10)
+		write(c, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(3)}, nil)
+		write(c, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(2.5), Hash: "abc"}, nil)
+
+		_, ok := c.get("counter", "PollCount", nil)
+		assert.False(t, ok, "total of counter isn't known")
+		m, ok := c.get("gauge", "Alloc", nil)
+		require.True(t, ok)
+		assert.Equal(t, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(2.5)}, m)
+	})
+
+	t.Run("[Negative] Ошибка записи удаляет серию из кэша", func(t *testing.T) {
+		c := newReadCache(10)
+		load(c, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(5)})
+		write(c, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(3)}, errors.New("commit result is unknown"))
+
+		_, ok := c.get("counter", "PollCount", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("[Negative] Чтение, во время которого началась запись, не кэшируется", func(t *testing.T) {
+		c := newReadCache(10)
+		m := models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(5)}
+		key := m.Key()
+
+		reading := c.startLoad(key)
+		write(c, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(3)}, nil)
+		// значение из БД могло быть прочитано до или после записи
+		c.finishLoad(key, reading, m, true)
+		_, ok := c.get("counter", "PollCount", nil)
+		assert.False(t, ok)
+
+		c.startWrite([]models.Metrics{m})
+		assert.Nil(t, c.startLoad(key), "series being written isn't loaded into cache")
+		c.finishWrite([]models.Metrics{m}, nil)
+	})
+
+	t.Run("[Negative] Gauge с параллельными записями удаляется из кэша", func(t *testing.T) {
+		c := newReadCache(10)
+		first := models.Metrics{ID: "Alloc", MType: "gauge", Value: value(1)}
+		second := models.Metrics{ID: "Alloc", MType: "gauge", Value: value(2)}
+		load(c, first)
+
+		c.startWrite([]models.Metrics{first})
+		c.startWrite([]models.Metrics{second})
+		c.finishWrite([]models.Metrics{second}, nil)
+		c.finishWrite([]models.Metrics{first}, nil)
+		_, ok := c.get("gauge", "Alloc", nil)
+		assert.False(t, ok, "order of commits is unknown")
+	})
+
+	t.Run("[Positive] Давно не читавшаяся серия вытесняется", func(t *testing.T) {
+		c := newReadCache(2)
+		load(c, models.Metrics{ID: "A", MType: "gauge", Value: value(1)})
+		load(c, models.Metrics{ID: "B", MType: "gauge", Value: value(2)})
+		c.get("gauge", "A", nil)
+		load(c, models.Metrics{ID: "C", MType: "gauge", Value: value(3)})
+
+		_, ok := c.get("gauge", "B", nil)
+		assert.False(t, ok)
+		_, ok = c.get("gauge", "A", nil)
+		assert.True(t, ok)
+		assert.True(t, c.full())
+	})
+
+	t.Run("[Negative] Прогрев пропускает серии, если во время чтения была запись", func(t *testing.T) {
+		c := newReadCache(10)
+		series := []models.Metrics{{ID: "PollCount", MType: "counter", Delta: delta(5)}}
+
+		writes := c.startBulkLoad()
+		write(c, models.Metrics{ID: "Other", MType: "counter", Delta: delta(1)}, nil)
+		assert.Equal(t, 0, c.finishBulkLoad(writes, series))
+
+		writes = c.startBulkLoad()
+		assert.Equal(t, 1, c.finishBulkLoad(writes, series))
+		_, ok := c.get("counter", "PollCount", nil)
+		assert.True(t, ok)
+	})
+}
+
+func TestReadCacheCounterConsistency(t *testing.T) {
+	c := newReadCache(10)
+	// database - значение счётчика в БД, запись и чтение атомарны, как транзакции
+	var mutex sync.Mutex
+	var database int64
+	counter := func(v int64) models.Metrics {
+		return models.Metrics{ID: "PollCount", MType: "counter", Delta: &v}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				m := counter(1)
+				c.startWrite([]models.Metrics{m})
+				mutex.Lock()
+				database++
+				mutex.Unlock()
+				c.finishWrite([]models.Metrics{m}, nil)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, ok := c.get("counter", "PollCount", nil); ok {
+					continue
+				}
+				key := counter(0).Key()
+				reading := c.startLoad(key)
+				mutex.Lock()
+				m := counter(database)
+				mutex.Unlock()
+				c.finishLoad(key, reading, m, true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m, ok := c.get("counter", "PollCount", nil)
+	if ok {
+		assert.Equal(t, database, *m.Delta)
+	}
+}
+
+func TestReadCacheStats(t *testing.T) {
+	c := newReadCache(5)
+	value := 1.5
+	c.finishLoad("Alloc", c.startLoad("Alloc"), models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}, true)
+	c.get("gauge", "Alloc", nil)
+	c.get("gauge", "HeapAlloc", nil)
+
+	var buf bytes.Buffer
+	require.NoError(t, c.writeStats(&buf))
+	assert.Contains(t, buf.String(), "db_cache_capacity 5\n")
+	assert.Contains(t, buf.String(), "db_cache_entries 1\n")
+	assert.Contains(t, buf.String(), "db_cache_hits_total 1\n")
+	assert.Contains(t, buf.String(), "db_cache_misses_total 1\n")
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// copyMetrics - load batch into temporary table with COPY and merge it into metrics by one statement.
+// Batch must not contain the same series twice, see mergeBatch.
+func copyMetrics(ctx context.Context, tx *sql.Tx, metrics []models.Metrics) error {
+	if _, err := tx.ExecContext(ctx, createStagingTable); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("metrics_staging", "id", "m_type", "delta", "value", "labels"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, m := range metrics {
+		if _, err = stmt.ExecContext(ctx, m.ID, m.MType, m.Delta, m.Value, m.Labels); err != nil {
+			return err
+		}
+	}
+	// вызов без аргументов завершает COPY
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, queryMergeStaging)
+	return err
+}
+
+// mergeBatch - one metric per series (type, name, labels) in order of first appearance:
+// counters are summed, the last gauge wins. Type is lowercased.
+// One statement can't update the same row twice, so COPY path needs merged batch.
+func mergeBatch(metrics []models.Metrics) []models.Metrics {
+	result := make([]models.Metrics, 0, len(metrics))
+	index := make(map[string]int, len(metrics))
+	for _, m := range metrics {
+		m.MType = strings.ToLower(m.MType)
+		key := seriesKey(m)
+		if i, ok := index[key]; ok {
+			mergeInto(&result[i], m)
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, m)
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestMergeBatch(t *testing.T) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+	host := models.Labels{"host": "h1"}
+
+	first := delta(2)
+	metrics := []models.Metrics{
+		{ID: "PollCount", MType: "Counter", Delta: first},
+		{ID: "Alloc", MType: "gauge", Value: value(1.5)},
+		{ID: "PollCount", MType: "counter", Delta: delta(3)},
+		{ID: "PollCount", MType: "counter", Delta: delta(10), Labels: host},
+		{ID: "Alloc", MType: "gauge", Value: value(2.5)},
+	}
+	merged := mergeBatch(metrics)
+
+	require.Len(t, merged, 3)
+	assert.Equal(t, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(5)}, merged[0], "counters are summed")
+	assert.Equal(t, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(2.5)}, merged[1], "the last gauge wins")
+	assert.Equal(t, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(10), Labels: host}, merged[2], "series with labels is separate")
+	assert.Equal(t, int64(2), *first, "batch must not be changed")
+}
+
+func benchBatch(size int) []models.Metrics {
+	metrics := make([]models.Metrics, 0, size)
+	for i := 0; i < size; i++ {
+		v := float64(i)
+		d := int64(i)
+		if i%2 == 0 {
+			metrics = append(metrics, models.Metrics{ID: fmt.Sprintf("bench_gauge_%d", i), MType: "gauge", Value: &v})
+		} else {
+			metrics = append(metrics, models.Metrics{ID: fmt.Sprintf("bench_counter_%d", i), MType: "counter", Delta: &d})
+		}
+	}
+	return metrics
+}
+
+func BenchmarkMergeBatch(b *testing.B) {
+	metrics := benchBatch(10000)
+	metrics = append(metrics, metrics...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mergeBatch(metrics)
+	}
+}
+
+// BenchmarkUpdateMetrics - prepared INSERT per metric vs COPY and merge, needs TEST_DATABASE_DSN
+func BenchmarkUpdateMetrics(b *testing.B) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN isn't set")
+	}
+	ctx := context.Background()
+	for _, size := range []int{100, 1000, 10000} {
+		metrics := benchBatch(size)
+		for _, path := range []struct {
+			name      string
+			threshold int
+		}{
+			{name: "insert", threshold: 0},
+			{name: "copy", threshold: 1},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", path.name, size), func(b *testing.B) {
+				storage, err := NewDB(dsn, Options{CopyThreshold: path.threshold})
+				require.NoError(b, err)
+				defer storage.Close()
+				require.NoError(b, storage.CreateTable(ctx))
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err = storage.UpdateMetrics(ctx, metrics); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "metrics/s")
+			})
+		}
+	}
+}
@@ -1,34 +1,194 @@
 package db
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/lib/pq"
-
-	"github.com/iddanilov/metricsAndAlerting/internal/models"
 )
 
 type DB struct {
-	DB     *sql.DB
-	buffer []models.Metrics
+	DB   *sql.DB
+	opts Options
+	// buffer - очередь UpdateMetric, nil если буфер отключён
+	buffer      *writeBuffer
+	flushMutex  sync.Mutex
+	stopFlusher chan struct{}
+	flusherDone chan struct{}
+	closeOnce   sync.Once
+	// cache - кэш чтения серий, nil если кэш отключён
+	cache *readCache
+}
+
+// Options - connection pool, timeouts and retries, zero value keeps database/sql and server defaults
+type Options struct {
+	// MaxOpenConns - maximum number of open connections, 0 - unlimited
+	MaxOpenConns int
+	// MaxIdleConns - maximum number of idle connections, 0 - database/sql default
+	MaxIdleConns int
+	// ConnMaxLifetime - connection is closed after the time, 0 - connections are reused forever
+	ConnMaxLifetime time.Duration
+	// StatementTimeout - statement_timeout of every connection, server aborts longer statements
+	StatementTimeout time.Duration
+	// QueryTimeout - deadline of every db method call if the context has no earlier one
+	QueryTimeout time.Duration
+	// RetryAttempts - maximum calls of a query failed with retriable error, 0 or 1 - without retries
+	RetryAttempts int
+	// RetryDelay - delay before the first retry, doubled before every next one
+	RetryDelay time.Duration
+	// RetryMaxDelay - maximum delay between retries
+	RetryMaxDelay time.Duration
+	// CopyThreshold - batches of this size or larger are loaded with COPY, 0 - COPY isn't used
+	CopyThreshold int
+	// BufferSize - maximum number of series queued by UpdateMetric, 0 - updates are written at once
+	BufferSize int
+	// FlushInterval - how often the write buffer is flushed if it isn't full
+	FlushInterval time.Duration
+	// CacheSize - maximum number of series in the read cache, 0 - reads go to the database.
+	// Cache is consistent only if the database is written by this server alone.
+	CacheSize int
 }
 
-func NewDB(DNS string) (*DB, error) {
+func NewDB(DNS string, opts Options) (*DB, error) {
+	if opts.StatementTimeout > 0 {
+		var err error
+		if DNS, err = withStatementTimeout(DNS, opts.StatementTimeout); err != nil {
+			return nil, err
+		}
+	}
 	db, err := sql.Open("postgres", DNS)
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
 	log.Println("DB Opened")
 
-	return &DB{
-		DB:     db,
-		buffer: make([]models.Metrics, 0, 1000),
-	}, nil
+	storage := &DB{
+		DB:   db,
+		opts: opts,
+	}
+	if opts.CacheSize > 0 {
+		storage.cache = newReadCache(opts.CacheSize)
+	}
+	if opts.BufferSize > 0 {
+		interval := opts.FlushInterval
+		if interval <= 0 {
+			interval = defaultFlushInterval
+		}
+		storage.buffer = newWriteBuffer(opts.BufferSize)
+		storage.stopFlusher = make(chan struct{})
+		storage.flusherDone = make(chan struct{})
+		go storage.runFlusher(interval)
+	}
+	return storage, nil
+}
+
+// withStatementTimeout - add statement_timeout run-time parameter to URL or key=value DSN.
+// lib/pq sends unknown connection parameters to the server on connect.
+func withStatementTimeout(dsn string, timeout time.Duration) (string, error) {
+	ms := strconv.FormatInt(timeout.Milliseconds(), 10)
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		query.Set("statement_timeout", ms)
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+	if dsn == "" {
+		return "statement_timeout=" + ms, nil
+	}
+	return dsn + " statement_timeout=" + ms, nil
+}
+
+// withTimeout - context with QueryTimeout deadline, earlier deadline of ctx is kept
+func (db *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.opts.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.opts.QueryTimeout)
 }
 
-func (db *DB) DBPing(ctx context.Context) error {
+func (db *DB) Ping(ctx context.Context) error {
+	if db.DB == nil {
+		return errors.New("you haven`t opened the database connection")
+	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 	return db.DB.PingContext(ctx)
+}
+
+// Close - flush the write buffer and close connections to the database
+func (db *DB) Close() error {
+	if db.DB == nil {
+		return nil
+	}
+	var err error
+	db.closeOnce.Do(func() {
+		if db.buffer != nil {
+			close(db.stopFlusher)
+			<-db.flusherDone
+			if err = db.Flush(context.Background()); err != nil {
+				log.Printf("Write buffer: %d series aren't saved: %v", db.buffer.depth(), err)
+			}
+		}
+		if closeErr := db.DB.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// WriteStats - write statistics of connection pool, write buffer and read cache in Prometheus text exposition format
+func (db *DB) WriteStats(w io.Writer) error {
+	if db.DB == nil {
+		return nil
+	}
+	if err := writePoolStats(w, db.DB.Stats()); err != nil {
+		return err
+	}
+	if db.buffer != nil {
+		if err := db.buffer.writeStats(w); err != nil {
+			return err
+		}
+	}
+	if db.cache != nil {
+		return db.cache.writeStats(w)
+	}
+	return nil
+}
+
+// writeMetric - write metric without labels with HELP and TYPE in Prometheus text exposition format
+func writeMetric(w io.Writer, name, mType, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, mType, name, value)
+}
 
+func writePoolStats(w io.Writer, stats sql.DBStats) error {
+	buf := bufio.NewWriter(w)
+	writeMetric(buf, "db_pool_max_open_connections", "gauge", "Maximum number of open connections.", float64(stats.MaxOpenConnections))
+	writeMetric(buf, "db_pool_open_connections", "gauge", "Number of established connections.", float64(stats.OpenConnections))
+	writeMetric(buf, "db_pool_in_use_connections", "gauge", "Number of connections currently in use.", float64(stats.InUse))
+	writeMetric(buf, "db_pool_idle_connections", "gauge", "Number of idle connections.", float64(stats.Idle))
+	writeMetric(buf, "db_pool_wait_count_total", "counter", "Total number of connections waited for.", float64(stats.WaitCount))
+	writeMetric(buf, "db_pool_wait_duration_seconds_total", "counter", "Total time blocked waiting for a new connection.", stats.WaitDuration.Seconds())
+	writeMetric(buf, "db_pool_max_idle_closed_total", "counter", "Total number of connections closed due to max idle connections.", float64(stats.MaxIdleClosed))
+	writeMetric(buf, "db_pool_max_idle_time_closed_total", "counter", "Total number of connections closed due to max idle time.", float64(stats.MaxIdleTimeClosed))
+	writeMetric(buf, "db_pool_max_lifetime_closed_total", "counter", "Total number of connections closed due to max lifetime.", float64(stats.MaxLifetimeClosed))
+	return buf.Flush()
 }
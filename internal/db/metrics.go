@@ -4,8 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/lib/pq"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
 	"github.com/iddanilov/metricsAndAlerting/internal/models"
 )
 
@@ -16,131 +23,311 @@ type Metrics struct {
 	Value *sql.NullFloat64 `db:"value"`
 }
 
+// CreateTable - migrate the schema to the latest version, migration is repeated after retriable errors
 func (db *DB) CreateTable(ctx context.Context) error {
-	row, err := db.DB.Query(checkMetricDB)
+	err := db.retry(ctx, func(ctx context.Context) error {
+		return db.Migrate(ctx, LatestVersion)
+	})
 	if err != nil {
-		if err.Error() == `pq: relation "metrics" does not exist` {
-			_, err = db.DB.ExecContext(ctx, createTable)
-			if err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	}
-	if row != nil {
-		if row.Err() != nil {
-			return err
-		}
-		defer row.Close()
+		return err
 	}
 	log.Println("DB Create")
 
 	return nil
 }
 
+// UpdateMetric - save metric, counter is accumulated, gauge is replaced.
+// With write buffer the metric of a known name is queued and saved by the next flush.
 func (db *DB) UpdateMetric(ctx context.Context, metrics models.Metrics) error {
-	_, err := db.DB.ExecContext(ctx, queryUpdateMetrics, metrics.ID, metrics.MType, metrics.Delta, metrics.Value)
-	if err != nil {
-		log.Println("Can't Update Metric")
+	if db.buffer != nil {
+		return db.bufferMetric(ctx, metrics)
 	}
-	return err
+	return db.UpdateMetrics(ctx, []models.Metrics{metrics})
 }
 
-func (db *DB) UpdateMetrics(metrics []models.Metrics) error {
+// UpdateMetrics - save batch of metrics in one transaction.
+// Batch of CopyThreshold metrics or more is loaded with COPY and merged by one statement.
+// Transaction is repeated after retriable errors, but not if connection is lost during commit.
+// Batch isn't buffered, queued updates are flushed before it to keep the order.
+func (db *DB) UpdateMetrics(ctx context.Context, metrics []models.Metrics) error {
 	if db.DB == nil {
 		return errors.New("you haven`t opened the database connection")
 	}
-	tx, err := db.DB.Begin()
+	db.flushPending(ctx)
+	if err := db.writeMetrics(ctx, metrics); err != nil {
+		log.Println("Can't update metrics", err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) writeMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if db.cache != nil {
+		db.cache.startWrite(metrics)
+	}
+	err := db.do(ctx, func(ctx context.Context) error {
+		return db.updateMetrics(ctx, metrics)
+	})
+	if db.cache != nil {
+		db.cache.finishWrite(metrics, err)
+	}
+	return err
+}
+
+func (db *DB) updateMetrics(ctx context.Context, metrics []models.Metrics) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println("Can't create tx", err)
 		return err
 	}
+	// после Commit откат ничего не делает
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println("Can't rollback tx", err)
+		}
+	}()
 
-	stmt, err := tx.Prepare(queryUpdateMetrics)
+	if err = registerTypes(ctx, tx, metrics); err != nil {
+		return err
+	}
+
+	if db.opts.CopyThreshold > 0 && len(metrics) >= db.opts.CopyThreshold {
+		err = copyMetrics(ctx, tx, mergeBatch(metrics))
+	} else {
+		err = upsertMetrics(ctx, tx, metrics)
+	}
 	if err != nil {
-		log.Println("Can't create stmt", err)
 		return err
 	}
 
+	if err = tx.Commit(); err != nil {
+		if isConnectionError(err) {
+			return fmt.Errorf("%w: %v", errUnknownCommit, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// upsertMetrics - save metrics one by one with prepared statement
+func upsertMetrics(ctx context.Context, tx *sql.Tx, metrics []models.Metrics) error {
+	stmt, err := tx.PrepareContext(ctx, queryUpdateMetrics)
+	if err != nil {
+		return err
+	}
 	defer stmt.Close()
 
 	for _, m := range metrics {
-		if _, err = stmt.Exec(m.ID, m.MType, m.Delta, m.Value); err != nil {
-			log.Println("Can't make Exec", err)
-			if err = tx.Rollback(); err != nil {
-				log.Fatalf("update drivers: unable to rollback: %v", err)
-			}
+		if _, err = stmt.ExecContext(ctx, m.ID, strings.ToLower(m.MType), m.Delta, m.Value, m.Labels); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	if err := tx.Commit(); err != nil {
-		log.Fatalf("update drivers: unable to commit: %v", err)
+// batchTypes - sorted metric names of the batch and their lowercase types.
+// Name sent with two types in one batch is a conflict.
+func batchTypes(metrics []models.Metrics) (names, types []string, err error) {
+	byName := make(map[string]string, len(metrics))
+	for _, m := range metrics {
+		mType := strings.ToLower(m.MType)
+		if saved, ok := byName[m.ID]; ok && saved != mType {
+			return nil, nil, fmt.Errorf("%w: metric %s is sent as %s and %s", middleware.ErrConflict, m.ID, saved, mType)
+		}
+		byName[m.ID] = mType
+	}
+	names = make([]string, 0, len(byName))
+	for id := range byName {
+		names = append(names, id)
+	}
+	sort.Strings(names)
+	types = make([]string, 0, len(names))
+	for _, id := range names {
+		types = append(types, byName[id])
+	}
+	return names, types, nil
+}
+
+// registerTypes - save types of new metric names in the transaction.
+// Metric with a name already saved with another type is rejected with middleware.ErrConflict,
+// so a gauge never overwrites a counter with the same name and vice versa.
+func registerTypes(ctx context.Context, tx *sql.Tx, metrics []models.Metrics) error {
+	names, types, err := batchTypes(metrics)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, queryInsertMetricTypes, pq.Array(names), pq.Array(types)); err != nil {
 		return err
 	}
+	rows, err := tx.QueryContext(ctx, queryGetMetricTypes, pq.Array(names))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, mType string
+		if err = rows.Scan(&id, &mType); err != nil {
+			return err
+		}
+		if i := sort.SearchStrings(names, id); i < len(names) && names[i] == id && types[i] != mType {
+			return fmt.Errorf("%w: metric %s is %s, not %s", middleware.ErrConflict, id, mType, types[i])
+		}
+	}
+	return rows.Err()
+}
 
-	db.buffer = db.buffer[:0]
-	return nil
+func (db *DB) GetMetric(ctx context.Context, metricID string, labels models.Labels) (models.Metrics, error) {
+	return db.cachedMetric(ctx, "", metricID, labels, queryGetMetric, metricID, labels)
+}
 
+// GetMetricByType - series of metric with the type, name and labels.
+func (db *DB) GetMetricByType(ctx context.Context, mType, metricID string, labels models.Labels) (models.Metrics, error) {
+	return db.cachedMetric(ctx, mType, metricID, labels, queryGetMetricByType, metricID, labels, mType)
 }
 
-func (db *DB) GetMetric(ctx context.Context, metricID string) (models.Metrics, error) {
+func (db *DB) queryMetric(ctx context.Context, query string, args ...interface{}) (models.Metrics, error) {
+	db.flushPending(ctx)
 	var dbMetric models.Metrics
-	row := db.DB.QueryRowContext(ctx, queryGetMetric, metricID)
-	err := row.Scan(&dbMetric.ID, &dbMetric.MType, &dbMetric.Delta, &dbMetric.Value)
+	err := db.do(ctx, func(ctx context.Context) error {
+		row := db.DB.QueryRowContext(ctx, query, args...)
+		return row.Scan(&dbMetric.ID, &dbMetric.MType, &dbMetric.Delta, &dbMetric.Value, &dbMetric.Labels)
+	})
 	if err != nil {
 		return models.Metrics{}, err
 	}
 	return dbMetric, nil
 }
 
-func (db *DB) GetMetricNames(ctx context.Context) ([]string, error) {
-	var result []string
-	rows, err := db.DB.QueryContext(ctx, queryGetMetricNames)
+// GetSeries - all series of metric with the name.
+func (db *DB) GetSeries(ctx context.Context, metricID string) ([]models.Metrics, error) {
+	return db.queryMetrics(ctx, queryGetSeries, metricID)
+}
+
+func (db *DB) GetMetrics(ctx context.Context) ([]models.Metrics, error) {
+	return db.queryMetrics(ctx, queryGetMetrics)
+}
+
+func (db *DB) queryMetrics(ctx context.Context, query string, args ...interface{}) ([]models.Metrics, error) {
+	db.flushPending(ctx)
+	var result []models.Metrics
+	err := db.do(ctx, func(ctx context.Context) error {
+		result = nil
+		rows, err := db.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var m models.Metrics
+			err = rows.Scan(&m.ID, &m.MType, &m.Delta, &m.Value, &m.Labels)
+			if err != nil {
+				return err
+			}
+			result = append(result, m)
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
-	// обязательно закрываем перед возвратом функции
-	defer rows.Close()
+	return result, nil
+}
 
-	// пробегаем по всем записям
-	for rows.Next() {
-		var v string
-		err = rows.Scan(&v)
+func (db *DB) GetMetricNames(ctx context.Context) ([]string, error) {
+	db.flushPending(ctx)
+	var result []string
+	err := db.do(ctx, func(ctx context.Context) error {
+		result = nil
+		rows, err := db.DB.QueryContext(ctx, queryGetMetricNames)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		// обязательно закрываем перед возвратом функции
+		defer rows.Close()
 
-		result = append(result, v)
-	}
+		// пробегаем по всем записям
+		for rows.Next() {
+			var v string
+			err = rows.Scan(&v)
+			if err != nil {
+				return err
+			}
 
-	// проверяем на ошибки
-	err = rows.Err()
+			result = append(result, v)
+		}
+
+		// проверяем на ошибки
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func (db *DB) GetCounterMetric(ctx context.Context, metricID string) (*int64, error) {
-	var result int64
-	row := db.DB.QueryRowContext(ctx, queryGetCounterMetricValue, metricID)
-	err := row.Scan(&result)
+func (db *DB) GetCounterMetric(ctx context.Context, metricID string, labels models.Labels) (*int64, error) {
+	m, err := db.GetMetricByType(ctx, "counter", metricID, labels)
+	if err == nil && m.Delta == nil {
+		err = fmt.Errorf("counter %s has no value", metricID)
+	}
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	return &result, nil
+	return m.Delta, nil
 }
 
-func (db *DB) GetGaugeMetric(ctx context.Context, metricID string) (*float64, error) {
-	var result float64
-	row := db.DB.QueryRowContext(ctx, queryGetGaugeMetricValue, metricID)
-	err := row.Scan(&result)
+func (db *DB) GetGaugeMetric(ctx context.Context, metricID string, labels models.Labels) (*float64, error) {
+	m, err := db.GetMetricByType(ctx, "gauge", metricID, labels)
+	if err == nil && m.Value == nil {
+		err = fmt.Errorf("gauge %s has no value", metricID)
+	}
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	return &result, nil
+	return m.Value, nil
+}
+
+// GetHistory - saved values of series between from and to ordered by time.
+func (db *DB) GetHistory(ctx context.Context, metricID string, labels models.Labels, from, to time.Time) ([]models.Sample, error) {
+	db.flushPending(ctx)
+	var result []models.Sample
+	err := db.do(ctx, func(ctx context.Context) error {
+		result = nil
+		rows, err := db.DB.QueryContext(ctx, queryGetHistory, metricID, labels, from, to)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sample models.Sample
+			err = rows.Scan(&sample.ID, &sample.MType, &sample.Delta, &sample.Value, &sample.Labels, &sample.Timestamp)
+			if err != nil {
+				return err
+			}
+			result = append(result, sample)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteHistory - remove history saved before the time. Returns number of removed rows.
+// Repeating the delete is safe, the second attempt removes the rest.
+func (db *DB) DeleteHistory(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	err := db.do(ctx, func(ctx context.Context) error {
+		result, err := db.DB.ExecContext(ctx, queryDeleteHistory, before)
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	return deleted, err
 }
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestBatchTypes(t *testing.T) {
+	t.Run("[Positive] Имена сортируются, тип в нижнем регистре", func(t *testing.T) {
+		names, types, err := batchTypes([]models.Metrics{
+			{ID: "PollCount", MType: "Counter"},
+			{ID: "Alloc", MType: "gauge"},
+			{ID: "PollCount", MType: "counter", Labels: models.Labels{"host": "h1"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Alloc", "PollCount"}, names)
+		assert.Equal(t, []string{"gauge", "counter"}, types)
+	})
+
+	t.Run("[Negative] Имя с двумя типами в одном batch", func(t *testing.T) {
+		_, _, err := batchTypes([]models.Metrics{
+			{ID: "Alloc", MType: "gauge"},
+			{ID: "Alloc", MType: "counter", Labels: models.Labels{"host": "h1"}},
+		})
+		assert.ErrorIs(t, err, middleware.ErrConflict)
+	})
+}
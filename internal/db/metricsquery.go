@@ -1,41 +1,111 @@
 package db
 
 const (
-	checkMetricDB = `
-select * from metrics;`
+	createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	         version bigint PRIMARY KEY,
+	         name varchar NOT NULL,
+	         applied_at timestamptz NOT NULL DEFAULT now());`
 
-	createTable = `
-CREATE TABLE metrics (
-	         id varchar primary key,
-	         m_type varchar NOT NULL,
-	         delta bigint,
-	         value double precision);`
-
-	queryGetCounterMetricValue = `
-SELECT delta FROM metrics WHERE id = $1 
+	queryLockMigrations = `
+SELECT pg_advisory_lock($1)
+`
+	queryUnlockMigrations = `
+SELECT pg_advisory_unlock($1)
+`
+	queryMigrationVersion = `
+SELECT COALESCE(MAX(version), 0) FROM schema_migrations
+`
+	queryGetMigrations = `
+SELECT version FROM schema_migrations ORDER BY version
+`
+	queryInsertMigration = `
+INSERT INTO schema_migrations(version, name) VALUES ($1, $2)
+`
+	queryDeleteMigration = `
+DELETE FROM schema_migrations WHERE version = $1
+`
+	// миграция может идти дольше statement_timeout обычных запросов
+	queryDisableStatementTimeout = `
+SET LOCAL statement_timeout = 0
 `
 
 	queryGetMetricNames = `
-SELECT id FROM metrics;
+SELECT DISTINCT id FROM metrics;
 `
 
 	queryGetMetric = `
-SELECT id, m_type, delta, value FROM metrics WHERE $1 = id
+SELECT id, m_type, delta, value, labels FROM metrics WHERE $1 = id AND labels = $2 ORDER BY m_type LIMIT 1
+`
+	queryGetMetricByType = `
+SELECT id, m_type, delta, value, labels FROM metrics WHERE m_type = lower($3) AND $1 = id AND labels = $2
+`
+	queryGetSeries = `
+SELECT id, m_type, delta, value, labels FROM metrics WHERE $1 = id
 `
-	queryGetGaugeMetricValue = `
-SELECT value FROM metrics WHERE id = $1
+	queryGetMetrics = `
+SELECT id, m_type, delta, value, labels FROM metrics;
+`
+
+	// имена сортированы: параллельные batch блокируют их в одном порядке
+	queryInsertMetricTypes = `
+INSERT INTO metric_types(id, m_type)
+SELECT * FROM unnest($1::varchar[], $2::varchar[])
+ON CONFLICT (id) DO NOTHING
+`
+	queryGetMetricTypes = `
+SELECT id, m_type FROM metric_types WHERE id = ANY($1::varchar[])
+`
+	queryGetMetricType = `
+SELECT m_type FROM metric_types WHERE id = $1
 `
 
 	queryUpdateMetrics = `
+WITH updated AS (
 INSERT INTO metrics(id,
 	m_type,
 	delta,
-	value)
-values ($1, $2, $3, $4)
-on conflict(id) do 
+	value,
+	labels)
+values ($1, $2, $3, $4, $5)
+on conflict(m_type, id, labels) do 
 update set 
-	m_type=excluded.m_type,
 	delta=metrics.delta+excluded.delta,
 	value=excluded.value
+RETURNING id, m_type, delta, value, labels)
+INSERT INTO metrics_history(id, m_type, delta, value, labels)
+SELECT id, m_type, delta, value, labels FROM updated
+`
+
+	// строки удаляются при завершении транзакции, таблица остаётся у соединения
+	createStagingTable = `
+CREATE TEMP TABLE IF NOT EXISTS metrics_staging (
+	id varchar NOT NULL,
+	m_type varchar NOT NULL,
+	delta bigint,
+	value double precision,
+	labels jsonb NOT NULL) ON COMMIT DELETE ROWS
+`
+	queryMergeStaging = `
+WITH updated AS (
+INSERT INTO metrics(id, m_type, delta, value, labels)
+SELECT id, m_type, delta, value, labels FROM metrics_staging
+on conflict(m_type, id, labels) do 
+update set 
+	delta=metrics.delta+excluded.delta,
+	value=excluded.value
+RETURNING id, m_type, delta, value, labels)
+INSERT INTO metrics_history(id, m_type, delta, value, labels)
+SELECT id, m_type, delta, value, labels FROM updated
+`
+
+	queryGetHistory = `
+SELECT id, m_type, delta, value, labels, created_at FROM metrics_history
+WHERE id = $1 AND labels = $2 AND created_at BETWEEN $3 AND $4
+ORDER BY created_at
+`
+
+	queryDeleteHistory = `
+DELETE FROM metrics_history WHERE created_at < $1
 `
 )
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID - key of pg_advisory_lock, only one server migrates the schema at a time
+const migrationLockID = 7305190001
+
+// LatestVersion - migrate to the last embedded migration
+const LatestVersion = -1
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration - numbered schema change with up and down sql
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus - migration and whether it's applied to the database
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// LoadMigrations - embedded migrations ordered by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: wrong file name", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d: different names %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s: up and down are required", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d_%s: versions must go one by one from 1", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// migrationPlan - migrations to apply from current to target version.
+// Up migrations go in ascending order, down migrations in descending.
+// With downOnly target must be lower than current version.
+func migrationPlan(migrations []Migration, current, target int, downOnly bool) (steps []Migration, up bool, err error) {
+	if target == LatestVersion {
+		target = len(migrations)
+	}
+	if target < 0 || target > len(migrations) {
+		return nil, false, fmt.Errorf("unknown migration version %d, latest is %d", target, len(migrations))
+	}
+	if current > len(migrations) {
+		return nil, false, fmt.Errorf("database version %d is newer than latest migration %d", current, len(migrations))
+	}
+	if downOnly && target >= current {
+		return nil, false, fmt.Errorf("can't roll back to version %d, database version is %d", target, current)
+	}
+	if target >= current {
+		return migrations[current:target], true, nil
+	}
+	for i := current - 1; i >= target; i-- {
+		steps = append(steps, migrations[i])
+	}
+	return steps, false, nil
+}
+
+// Migrate - apply migrations up or down to the target version, LatestVersion means the last migration.
+// Servers starting together wait for each other on advisory lock.
+// QueryTimeout isn't applied: migration may run long, deadline is set by ctx.
+func (db *DB) Migrate(ctx context.Context, target int) error {
+	return db.migrate(ctx, target, false)
+}
+
+// MigrateDown - roll back migrations to the target version, which must be lower than version of the database.
+func (db *DB) MigrateDown(ctx context.Context, target int) error {
+	return db.migrate(ctx, target, true)
+}
+
+func (db *DB) migrate(ctx context.Context, target int, downOnly bool) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, queryLockMigrations, migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		// блокировка снимается на том же соединении
+		if _, err := conn.ExecContext(context.Background(), queryUnlockMigrations, migrationLockID); err != nil {
+			log.Println("Can't unlock migrations", err)
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return err
+	}
+	current, err := migrationVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	steps, up, err := migrationPlan(migrations, current, target, downOnly)
+	if err != nil {
+		return err
+	}
+	for _, m := range steps {
+		if err = applyMigration(ctx, conn, m, up); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, queryDisableStatementTimeout); err != nil {
+		return err
+	}
+	if up {
+		log.Printf("Migrate up %d_%s", m.Version, m.Name)
+		if _, err = tx.ExecContext(ctx, m.Up); err != nil {
+			return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, queryInsertMigration, m.Version, m.Name)
+	} else {
+		log.Printf("Migrate down %d_%s", m.Version, m.Name)
+		if _, err = tx.ExecContext(ctx, m.Down); err != nil {
+			return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, queryDeleteMigration, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrationVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(ctx, queryMigrationVersion).Scan(&version)
+	return version, err
+}
+
+// MigrationStatus - embedded migrations and whether they are applied
+func (db *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	if _, err = db.DB.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool)
+	rows, err := db.DB.QueryContext(ctx, queryGetMigrations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		result = append(result, MigrationStatus{Migration: m, Applied: applied[m.Version]})
+	}
+	return result, nil
+}
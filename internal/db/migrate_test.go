@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Up, m.Name)
+		assert.NotEmpty(t, m.Down, m.Name)
+	}
+}
+
+func TestMigrationPlan(t *testing.T) {
+	migrations := []Migration{{Version: 1, Name: "a"}, {Version: 2, Name: "b"}, {Version: 3, Name: "c"}}
+	versions := func(steps []Migration) []int {
+		result := make([]int, 0, len(steps))
+		for _, m := range steps {
+			result = append(result, m.Version)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name    string
+		current int
+		target  int
+		steps   []int
+		up      bool
+		down    bool
+		wantErr bool
+	}{
+		{name: "[Positive] Новая база - применяются все миграции", current: 0, target: LatestVersion, steps: []int{1, 2, 3}, up: true},
+		{name: "[Positive] Применяются только новые миграции", current: 2, target: LatestVersion, steps: []int{3}, up: true},
+		{name: "[Positive] База в актуальной версии - миграций нет", current: 3, target: 3, steps: []int{}, up: true},
+		{name: "[Positive] Откат идёт в обратном порядке", current: 3, target: 1, steps: []int{3, 2}},
+		{name: "[Positive] Откат всех миграций", current: 2, target: 0, steps: []int{2, 1}},
+		{name: "[Negative] Неизвестная версия", current: 1, target: 4, wantErr: true},
+		{name: "[Negative] База новее бинарника", current: 4, target: LatestVersion, wantErr: true},
+		{name: "[Positive] Откат на предыдущую версию", current: 3, target: 2, down: true, steps: []int{3}},
+		{name: "[Negative] Откат на версию выше текущей", current: 1, target: 3, down: true, wantErr: true},
+		{name: "[Negative] Откат на текущую версию", current: 2, target: 2, down: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, up, err := migrationPlan(migrations, tt.current, tt.target, tt.down)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.steps, versions(steps))
+			assert.Equal(t, tt.up, up)
+		})
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS metrics (
+	id varchar NOT NULL,
+	m_type varchar NOT NULL,
+	delta bigint,
+	value double precision,
+	PRIMARY KEY (id));
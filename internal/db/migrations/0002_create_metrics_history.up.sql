@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS metrics_history (
+	id varchar NOT NULL,
+	m_type varchar NOT NULL,
+	delta bigint,
+	value double precision,
+	created_at timestamptz NOT NULL DEFAULT now());
+CREATE INDEX IF NOT EXISTS metrics_history_id_created_at_idx ON metrics_history (id, created_at);
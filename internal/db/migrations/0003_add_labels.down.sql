@@ -0,0 +1,7 @@
+-- серии с метками не помещаются в ключ (id)
+DELETE FROM metrics WHERE labels <> '{}';
+DELETE FROM metrics_history WHERE labels <> '{}';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (id);
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
+ALTER TABLE metrics_history DROP COLUMN IF EXISTS labels;
@@ -0,0 +1,13 @@
+-- таблицы могли быть созданы до появления меток
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE metrics_history ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+DO $$
+BEGIN
+	IF NOT EXISTS (
+		SELECT 1 FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = 'metrics'::regclass AND i.indisprimary AND a.attname = 'labels') THEN
+		ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+		ALTER TABLE metrics ADD PRIMARY KEY (id, labels);
+	END IF;
+END $$;
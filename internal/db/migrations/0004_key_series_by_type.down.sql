@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS metric_types;
+-- без типа в ключе у имени и меток остаётся одна серия
+DELETE FROM metrics a USING metrics b
+WHERE a.id = b.id AND a.labels = b.labels AND a.m_type > b.m_type;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (id, labels);
@@ -0,0 +1,23 @@
+-- тип в нижнем регистре: Gauge и gauge - одна серия
+UPDATE metrics SET m_type = lower(m_type) WHERE m_type <> lower(m_type);
+UPDATE metrics_history SET m_type = lower(m_type) WHERE m_type <> lower(m_type);
+-- имя с сериями разных типов нельзя закрепить за одним типом, такие серии исправляются вручную
+DO $$
+DECLARE
+	conflicts text;
+BEGIN
+	SELECT string_agg(id, ', ' ORDER BY id) INTO conflicts
+	FROM (SELECT id FROM metrics GROUP BY id HAVING count(DISTINCT m_type) > 1) AS mixed;
+	IF conflicts IS NOT NULL THEN
+		RAISE EXCEPTION 'metrics are saved with several types: %. Delete series of one type and migrate again', conflicts;
+	END IF;
+END $$;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (m_type, id, labels);
+-- тип каждого имени метрики: обновления с другим типом отклоняются
+CREATE TABLE IF NOT EXISTS metric_types (
+	id varchar NOT NULL PRIMARY KEY,
+	m_type varchar NOT NULL);
+INSERT INTO metric_types(id, m_type)
+SELECT DISTINCT id, m_type FROM metrics
+ON CONFLICT (id) DO NOTHING;
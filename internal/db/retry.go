@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
+)
+
+// errUnknownCommit - connection is lost during commit, transaction may be applied,
+// so it isn't retried: counters would be accumulated twice
+var errUnknownCommit = errors.New("commit result is unknown")
+
+// readyDelay - first delay of WaitReady if RetryDelay isn't set
+const readyDelay = 100 * time.Millisecond
+
+// retriableCodes - Postgres errors after which the statement or transaction is rolled back
+// and may be repeated
+var retriableCodes = map[pq.ErrorCode]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// IsRetriable - the operation failed without changes in the database and may be repeated:
+// connection is refused or broken, server is restarting, transaction is serialization or deadlock victim.
+func IsRetriable(err error) bool {
+	if err == nil || errors.Is(err, errUnknownCommit) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if isConnectionError(err) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return retriableCodes[pqErr.Code] || pqErr.Code.Class() == "08"
+	}
+	return false
+}
+
+// isConnectionError - database is unreachable or connection is broken
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Class() == "08"
+}
+
+// do - call op with QueryTimeout deadline, repeat it after retriable errors
+func (db *DB) do(ctx context.Context, op func(ctx context.Context) error) error {
+	return db.retry(ctx, func(ctx context.Context) error {
+		ctx, cancel := db.withTimeout(ctx)
+		defer cancel()
+		return op(ctx)
+	})
+}
+
+// retry - call op, repeat it after retriable errors.
+// Delay between attempts doubles from RetryDelay up to RetryMaxDelay, at most RetryAttempts calls.
+// Connection error after the last attempt is returned as middleware.DisconnectDB.
+func (db *DB) retry(ctx context.Context, op func(ctx context.Context) error) error {
+	attempts := db.opts.RetryAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := db.opts.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts || !IsRetriable(err) {
+			if isConnectionError(err) {
+				return fmt.Errorf("%w: %v", middleware.DisconnectDB, err)
+			}
+			return err
+		}
+		log.Printf("DB attempt %d of %d failed, retry after %v: %v", attempt, attempts, delay, err)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		delay = nextDelay(delay, db.opts.RetryMaxDelay)
+	}
+}
+
+// WaitReady - ping the database until it's available or ctx is done, used on startup
+func (db *DB) WaitReady(ctx context.Context) error {
+	delay := db.opts.RetryDelay
+	if delay <= 0 {
+		delay = readyDelay
+	}
+	var lastErr error
+	for {
+		err := db.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		// дедлайн мог истечь во время ping, тогда возвращаем причину недоступности
+		if ctx.Err() != nil && lastErr != nil {
+			return fmt.Errorf("%w: %v", middleware.DisconnectDB, lastErr)
+		}
+		if !IsRetriable(err) {
+			return err
+		}
+		lastErr = err
+		log.Printf("DB isn't available, retry after %v: %v", delay, err)
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return fmt.Errorf("%w: %v", middleware.DisconnectDB, err)
+		}
+		delay = nextDelay(delay, db.opts.RetryMaxDelay)
+	}
+}
+
+func nextDelay(delay, max time.Duration) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	delay *= 2
+	if max > 0 && delay > max {
+		return max
+	}
+	return delay
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
+)
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "[Positive] Соединение отклонено", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, want: true},
+		{name: "[Positive] Разорванное соединение", err: fmt.Errorf("query: %w", driver.ErrBadConn), want: true},
+		{name: "[Positive] Ошибка сериализации", err: &pq.Error{Code: "40001"}, want: true},
+		{name: "[Positive] Deadlock", err: &pq.Error{Code: "40P01"}, want: true},
+		{name: "[Positive] Сервер перезапускается", err: &pq.Error{Code: "57P03"}, want: true},
+		{name: "[Positive] Класс ошибок соединения", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "[Negative] Ошибка в запросе", err: &pq.Error{Code: "42601"}, want: false},
+		{name: "[Negative] Нарушение уникальности", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "[Negative] Нет строк", err: sql.ErrNoRows, want: false},
+		{name: "[Negative] Истёк дедлайн запроса", err: context.DeadlineExceeded, want: false},
+		{name: "[Negative] Соединение потеряно при commit", err: fmt.Errorf("%w: %v", errUnknownCommit, driver.ErrBadConn), want: false},
+		{name: "[Negative] Нет ошибки", err: nil, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetriable(tt.err))
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	storage := &DB{opts: Options{RetryAttempts: 3, RetryDelay: time.Millisecond, RetryMaxDelay: 2 * time.Millisecond}}
+
+	t.Run("[Positive] Временная ошибка повторяется до успеха", func(t *testing.T) {
+		calls := 0
+		err := storage.do(context.Background(), func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return &pq.Error{Code: "40001"}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("[Negative] Количество попыток ограничено, ошибка соединения - DisconnectDB", func(t *testing.T) {
+		calls := 0
+		err := storage.do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return driver.ErrBadConn
+		})
+		assert.ErrorIs(t, err, middleware.DisconnectDB)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("[Negative] Постоянная ошибка не повторяется", func(t *testing.T) {
+		calls := 0
+		err := storage.do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return sql.ErrNoRows
+		})
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("[Negative] Отмена запроса прерывает повторы", func(t *testing.T) {
+		slow := &DB{opts: Options{RetryAttempts: 5, RetryDelay: time.Hour}}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		calls := 0
+		err := slow.do(ctx, func(ctx context.Context) error {
+			calls++
+			return driver.ErrBadConn
+		})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestNextDelay(t *testing.T) {
+	assert.Equal(t, 200*time.Millisecond, nextDelay(100*time.Millisecond, time.Second))
+	assert.Equal(t, time.Second, nextDelay(800*time.Millisecond, time.Second))
+	assert.Equal(t, 2*time.Second, nextDelay(time.Second, 0))
+	assert.Equal(t, time.Duration(0), nextDelay(0, time.Second))
+}
+
+func TestWaitReady(t *testing.T) {
+	// порт без сервера: соединение отклоняется, ожидание заканчивается по дедлайну
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().(*net.TCPAddr)
+	require.NoError(t, ln.Close())
+
+	dsn := fmt.Sprintf("host=127.0.0.1 port=%d sslmode=disable", addr.Port)
+	storage, err := NewDB(dsn, Options{RetryDelay: 10 * time.Millisecond, RetryMaxDelay: 20 * time.Millisecond})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = storage.WaitReady(ctx)
+	assert.ErrorIs(t, err, middleware.DisconnectDB)
+}
@@ -0,0 +1,67 @@
+// Package kv is an embedded key-value storage of metrics on top of bbolt.
+// Every update is committed to the file before the response, so metrics survive a crash.
+package kv
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metricsBucket = []byte("metrics")
+	historyBucket = []byte("history")
+)
+
+// ErrNotFound - metric isn't saved in storage
+var ErrNotFound = errors.New("metric not found")
+
+type DB struct {
+	DB *bolt.DB
+}
+
+// NewDB - open or create storage file
+func NewDB(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metricsBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Println("KV storage opened", path)
+
+	return &DB{DB: db}, nil
+}
+
+func (db *DB) Ping(ctx context.Context) error {
+	if db.DB == nil {
+		return errors.New("you haven`t opened the storage")
+	}
+	return db.DB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(metricsBucket) == nil {
+			return errors.New("metrics bucket doesn't exist")
+		}
+		return nil
+	})
+}
+
+// Close - close storage file
+func (db *DB) Close() error {
+	if db.DB == nil {
+		return nil
+	}
+	return db.DB.Close()
+}
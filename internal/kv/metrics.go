@@ -0,0 +1,190 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// UpdateMetric - save metric: counter is accumulated, gauge is replaced.
+func (db *DB) UpdateMetric(ctx context.Context, m models.Metrics) error {
+	return db.UpdateMetrics(ctx, []models.Metrics{m})
+}
+
+// UpdateMetrics - save batch of metrics and their history in one transaction.
+func (db *DB) UpdateMetrics(ctx context.Context, metrics []models.Metrics) error {
+	now := time.Now()
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metricsBucket)
+		history := tx.Bucket(historyBucket)
+		for _, m := range metrics {
+			key := []byte(m.Key())
+			if strings.EqualFold(m.MType, "counter") && m.Delta != nil {
+				delta := *m.Delta
+				if data := bucket.Get(key); data != nil {
+					var saved models.Metrics
+					if err := json.Unmarshal(data, &saved); err != nil {
+						return err
+					}
+					if saved.Delta != nil {
+						delta += *saved.Delta
+					}
+				}
+				m.Delta = &delta
+			}
+			m.Hash = ""
+			data, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put(key, data); err != nil {
+				return err
+			}
+
+			series, err := history.CreateBucketIfNotExists(key)
+			if err != nil {
+				return err
+			}
+			seq, err := series.NextSequence()
+			if err != nil {
+				return err
+			}
+			sample, err := json.Marshal(models.NewSample(m, now))
+			if err != nil {
+				return err
+			}
+			if err = series.Put(historyKey(now, seq), sample); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMetric - series of metric with the name and labels.
+func (db *DB) GetMetric(ctx context.Context, metricID string, labels models.Labels) (models.Metrics, error) {
+	var m models.Metrics
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(metricsBucket).Get([]byte(models.Metrics{ID: metricID, Labels: labels}.Key()))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &m)
+	})
+	return m, err
+}
+
+// GetMetricByType - series of metric with the type, name and labels.
+func (db *DB) GetMetricByType(ctx context.Context, mType, metricID string, labels models.Labels) (models.Metrics, error) {
+	m, err := db.GetMetric(ctx, metricID, labels)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	if !strings.EqualFold(m.MType, mType) {
+		return models.Metrics{}, ErrNotFound
+	}
+	return m, nil
+}
+
+// GetSeries - all series of metric with the name.
+func (db *DB) GetSeries(ctx context.Context, metricID string) ([]models.Metrics, error) {
+	var result []models.Metrics
+	prefix := []byte(metricID)
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(metricsBucket).Cursor()
+		// ключ серии начинается с имени метрики
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var m models.Metrics
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if m.ID == metricID {
+				result = append(result, m)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetMetrics - all series of all metrics.
+func (db *DB) GetMetrics(ctx context.Context) ([]models.Metrics, error) {
+	var result []models.Metrics
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metricsBucket).ForEach(func(k, v []byte) error {
+			var m models.Metrics
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			result = append(result, m)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// GetHistory - saved values of series between from and to ordered by time.
+func (db *DB) GetHistory(ctx context.Context, metricID string, labels models.Labels, from, to time.Time) ([]models.Sample, error) {
+	var result []models.Sample
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		series := tx.Bucket(historyBucket).Bucket([]byte(models.Metrics{ID: metricID, Labels: labels}.Key()))
+		if series == nil {
+			return nil
+		}
+		c := series.Cursor()
+		end := historyKey(to, 1<<64-1)
+		for k, v := c.Seek(historyKey(from, 0)); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+			var sample models.Sample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return err
+			}
+			result = append(result, sample)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// DeleteHistory - remove history saved before the time. Returns number of removed samples.
+func (db *DB) DeleteHistory(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	end := historyKey(before, 0)
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket)
+		return history.ForEach(func(name, _ []byte) error {
+			series := history.Bucket(name)
+			if series == nil {
+				return nil
+			}
+			// удаление во время обхода курсором пропускает ключи, поэтому сначала собираем их
+			var keys [][]byte
+			c := series.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			for _, k := range keys {
+				if err := series.Delete(k); err != nil {
+					return err
+				}
+			}
+			deleted += int64(len(keys))
+			return nil
+		})
+	})
+	return deleted, err
+}
+
+// historyKey - timestamp and sequence in big endian, keys are sorted by time
+func historyKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
@@ -0,0 +1,88 @@
+package kv
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestUpdateMetrics(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	db, err := NewDB(path)
+	require.NoError(t, err)
+
+	value := 1.5
+	delta := int64(2)
+	labels := models.Labels{"host": "h1"}
+	require.NoError(t, db.UpdateMetrics(ctx, []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "Alloc", MType: "gauge", Value: &value, Labels: labels},
+		{ID: "AllocX", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}))
+	require.NoError(t, db.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}))
+	assert.Equal(t, int64(2), delta, "delta of request must not be changed")
+
+	// данные сохранены в файле и доступны после переоткрытия
+	require.NoError(t, db.Close())
+	db, err = NewDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Ping(ctx))
+
+	m, err := db.GetMetricByType(ctx, "counter", "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *m.Delta)
+	_, err = db.GetMetricByType(ctx, "gauge", "PollCount", nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = db.GetMetric(ctx, "Unknown", nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	series, err := db.GetSeries(ctx, "Alloc")
+	require.NoError(t, err)
+	if assert.Len(t, series, 2) {
+		assert.Equal(t, "Alloc", series[0].Key())
+		assert.Equal(t, `Alloc{host="h1"}`, series[1].Key())
+	}
+	metrics, err := db.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 4)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewDB(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	delta := int64(1)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}))
+	}
+	now := time.Now()
+
+	samples, err := db.GetHistory(ctx, "PollCount", nil, now.Add(-time.Minute), now)
+	require.NoError(t, err)
+	if assert.Len(t, samples, 3) {
+		// в истории хранится накопленное значение счётчика
+		assert.Equal(t, int64(1), *samples[0].Delta)
+		assert.Equal(t, int64(3), *samples[2].Delta)
+	}
+	samples, err = db.GetHistory(ctx, "PollCount", nil, now.Add(time.Second), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	deleted, err := db.DeleteHistory(ctx, now.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+	samples, err = db.GetHistory(ctx, "PollCount", nil, now.Add(-time.Minute), now)
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
@@ -6,6 +6,9 @@ var (
 	ErrNotFound       = NewAppError(nil, "not found")
 	UnknownMetricName = NewAppError(nil, "unknown metric name")
 	DisconnectDB      = NewAppError(nil, "driver: bad connection")
+	ErrBadRequest     = NewAppError(nil, "bad request")
+	ErrInternal       = NewAppError(nil, "internal system error")
+	ErrConflict       = NewAppError(nil, "metric type conflict")
 )
 
 type AppError struct {
@@ -43,6 +43,27 @@ func Middleware(h appHandler) gin.HandlerFunc {
 						w.WriteHeader(http.StatusInternalServerError)
 					}
 					return
+				} else if errors.Is(err, ErrBadRequest) {
+					w.WriteHeader(http.StatusBadRequest)
+					_, err := w.Write(NewAppError(err, err.Error()).Marshal())
+					if err != nil {
+						w.WriteHeader(http.StatusInternalServerError)
+					}
+					return
+				} else if errors.Is(err, ErrInternal) {
+					w.WriteHeader(http.StatusInternalServerError)
+					_, err := w.Write(NewAppError(err, err.Error()).Marshal())
+					if err != nil {
+						w.WriteHeader(http.StatusInternalServerError)
+					}
+					return
+				} else if errors.Is(err, ErrConflict) {
+					w.WriteHeader(http.StatusConflict)
+					_, err := w.Write(NewAppError(err, err.Error()).Marshal())
+					if err != nil {
+						w.WriteHeader(http.StatusInternalServerError)
+					}
+					return
 				} else if errors.Is(err, DisconnectDB) {
 					w.WriteHeader(http.StatusInternalServerError)
 					_, err := w.Write(DisconnectDB.Marshal())
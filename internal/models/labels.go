@@ -0,0 +1,201 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// InstanceLabel - label with id of the agent which sent the metric.
+	InstanceLabel = "instance"
+	// AgentHeader - http header with id of the agent.
+	AgentHeader = "X-Agent-ID"
+)
+
+// Labels - label set of metric series. Series is identified by metric name and labels.
+type Labels map[string]string
+
+// String - canonical form of labels: {a="1",b="2"}. Empty labels are "".
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Equal - check that label sets are the same. Nil and empty labels are equal.
+func (l Labels) Equal(other Labels) bool {
+	if len(l) != len(other) {
+		return false
+	}
+	for name, value := range l {
+		if v, ok := other[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy - copy of labels.
+func (l Labels) Copy() Labels {
+	if l == nil {
+		return nil
+	}
+	result := make(Labels, len(l))
+	for name, value := range l {
+		result[name] = value
+	}
+	return result
+}
+
+// Value - labels are saved in db as jsonb.
+func (l Labels) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan - read labels from db.
+func (l *Labels) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("can't scan labels from %T", src)
+	}
+	var result map[string]string
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		*l = nil
+		return nil
+	}
+	*l = result
+	return nil
+}
+
+// MatchType - type of label matcher.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher - condition on label value. Missing label has empty value.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher - create matcher. Regexp is anchored like in Prometheus.
+func NewMatcher(name string, t MatchType, value string) (Matcher, error) {
+	m := Matcher{Name: name, Type: t, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return Matcher{}, err
+		}
+		m.re = re
+	default:
+		return Matcher{}, fmt.Errorf("unknown match type %q", t)
+	}
+	return m, nil
+}
+
+// Matches - check label value.
+func (m Matcher) Matches(labels Labels) bool {
+	value := labels[m.Name]
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+// MatchLabels - check that labels satisfy all matchers.
+func MatchLabels(labels Labels, matchers []Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+var matcherRe = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*"((?:[^"\\]|\\.)*)"\s*(?:,|$)`)
+
+// ParseMatchers - parse selector like {host="a",env!~"dev.*"}. Braces are optional.
+func ParseMatchers(selector string) ([]Matcher, error) {
+	selector = strings.TrimSpace(selector)
+	if strings.HasPrefix(selector, "{") {
+		if !strings.HasSuffix(selector, "}") {
+			return nil, errors.New("selector should end with }")
+		}
+		selector = selector[1 : len(selector)-1]
+	}
+	var result []Matcher
+	for strings.TrimSpace(selector) != "" {
+		parts := matcherRe.FindStringSubmatch(selector)
+		if parts == nil {
+			return nil, fmt.Errorf("invalid label matcher: %s", selector)
+		}
+		value, err := strconv.Unquote(`"` + parts[3] + `"`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label value: %s", parts[3])
+		}
+		m, err := NewMatcher(parts[1], MatchType(parts[2]), value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+		selector = selector[len(parts[0]):]
+	}
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelsString(t *testing.T) {
+	assert.Equal(t, "", Labels(nil).String())
+	assert.Equal(t, `{env="prod",host="h\"1"}`, Labels{"host": `h"1`, "env": "prod"}.String())
+
+	value := 5.5
+	m := Metrics{ID: "Alloc", MType: "Gauge", Value: &value, Labels: Labels{"host": "h1"}}
+	assert.Equal(t, `Alloc{host="h1"}`, m.Key())
+	assert.Equal(t, `Alloc{host="h1"}:gauge:5.500000`, m.HashData())
+	m.Labels = nil
+	assert.Equal(t, "Alloc:gauge:5.500000", m.HashData())
+}
+
+func TestParseMatchers(t *testing.T) {
+	labels := Labels{"host": "h42", "env": "prod"}
+	tests := []struct {
+		name     string
+		selector string
+		match    bool
+		wantErr  bool
+	}{
+		{name: "[Positive] Пустой селектор", selector: "", match: true},
+		{name: "[Positive] Равенство", selector: `{host="h42"}`, match: true},
+		{name: "[Positive] Без фигурных скобок", selector: `host="h42", env="prod"`, match: true},
+		{name: "[Positive] Неравенство", selector: `{env!="prod"}`, match: false},
+		{name: "[Positive] Регулярное выражение", selector: `{host=~"h4.*",env!~"dev|test"}`, match: true},
+		{name: "[Positive] Регулярное выражение привязано к началу и концу", selector: `{host=~"4"}`, match: false},
+		{name: "[Positive] Отсутствующая метка равна пустой строке", selector: `{dc=""}`, match: true},
+		{name: "[Negative] Значение без кавычек", selector: `{host=h42}`, wantErr: true},
+		{name: "[Negative] Некорректное регулярное выражение", selector: `{host=~"("}`, wantErr: true},
+		{name: "[Negative] Незакрытая скобка", selector: `{host="h42"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := ParseMatchers(tt.selector)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.match, MatchLabels(labels, matchers))
+		})
+	}
+}
+
+func TestLabelsScan(t *testing.T) {
+	var labels Labels
+	require.NoError(t, labels.Scan([]byte(`{"host":"h1"}`)))
+	assert.Equal(t, Labels{"host": "h1"}, labels)
+	require.NoError(t, labels.Scan([]byte(`{}`)))
+	assert.Nil(t, labels)
+
+	value, err := Labels(nil).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "{}", value)
+}
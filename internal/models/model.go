@@ -1,10 +1,13 @@
 package models
 
 import (
+	"fmt"
 	"log"
 	"math/rand"
 	"reflect"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/shirou/gopsutil/v3/mem"
 )
@@ -12,17 +15,70 @@ import (
 type Counter int64
 
 type Metrics struct {
-	ID    string   `json:"id" db:"id"`                 // имя метрики
-	MType string   `json:"type" db:"m_type"`           // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty" db:"delta"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty" db:"value"` // значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`             // значение хеш-функции
+	ID     string   `json:"id" db:"id"`                   // имя метрики
+	MType  string   `json:"type" db:"m_type"`             // параметр, принимающий значение gauge или counter
+	Delta  *int64   `json:"delta,omitempty" db:"delta"`   // значение метрики в случае передачи counter
+	Value  *float64 `json:"value,omitempty" db:"value"`   // значение метрики в случае передачи gauge
+	Hash   string   `json:"hash,omitempty"`               // значение хеш-функции
+	Labels Labels   `json:"labels,omitempty" db:"labels"` // метки серии
+}
+
+// Sample - metric value saved by the server at the moment of update.
+// For counter Delta is a total value after update.
+type Sample struct {
+	ID        string    `json:"id"`
+	MType     string    `json:"type"`
+	Labels    Labels    `json:"labels,omitempty"`
+	Delta     *int64    `json:"delta,omitempty"`
+	Value     *float64  `json:"value,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewSample - create sample from metric. Values are copied.
+func NewSample(m Metrics, timestamp time.Time) Sample {
+	sample := Sample{
+		ID:        m.ID,
+		MType:     m.MType,
+		Labels:    m.Labels.Copy(),
+		Timestamp: timestamp,
+	}
+	if m.Delta != nil {
+		delta := *m.Delta
+		sample.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *m.Value
+		sample.Value = &value
+	}
+	return sample
 }
 
 func (m Metrics) MetricISEmpty() bool {
 	return m.ID == ""
 }
 
+// Key - series identity: name and labels, for example Alloc{host="h1"}.
+func (m Metrics) Key() string {
+	return m.ID + m.Labels.String()
+}
+
+// HashData - string for hash calculation: id:gauge:value or id:counter:delta.
+// Labels are added to id if metric has them.
+func (m Metrics) HashData() string {
+	if strings.EqualFold(m.MType, "gauge") {
+		var value float64
+		if m.Value != nil {
+			value = *m.Value
+		}
+		return fmt.Sprintf("%s:gauge:%f", m.Key(), value)
+	}
+	var delta int64
+	if m.Delta != nil {
+		delta = *m.Delta
+	}
+	return fmt.Sprintf("%s:counter:%d", m.Key(), delta)
+}
+
 var (
 	gaugeMetric = [...]string{
 		"Alloc", "BuckHashSys", "Frees", "GCCPUFraction",
@@ -0,0 +1,199 @@
+// Package prometheus - Prometheus text exposition format and remote write protocol.
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// ContentType - content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type family struct {
+	name   string
+	mType  string
+	help   string
+	series []textSeries
+}
+
+// familyKey - family of metrics with the sanitized name and the type
+type familyKey struct {
+	name  string
+	mType string
+}
+
+type textSeries struct {
+	labels string
+	metric models.Metrics
+}
+
+// WriteText - write gauges and counters in Prometheus text exposition format.
+// Metric names are sanitized, families are sorted by name.
+// If gauge and counter have the same name after sanitizing, type is added to the name of the second one.
+// If several metrics give the same series after sanitizing, the one with the name which needs no
+// sanitizing is written, otherwise the one with the least name.
+func WriteText(w io.Writer, metrics []models.Metrics) error {
+	families := make(map[familyKey]*family)
+	// byName - families by name in exposition, name is unique for all types
+	byName := make(map[string]*family)
+	for _, m := range metrics {
+		mType := strings.ToLower(m.MType)
+		if (mType == "gauge" && m.Value == nil) || (mType == "counter" && m.Delta == nil) {
+			continue
+		}
+		if mType != "gauge" && mType != "counter" {
+			continue
+		}
+		key := familyKey{name: SanitizeName(m.ID), mType: mType}
+		f, ok := families[key]
+		if !ok {
+			f = &family{
+				name:  familyName(byName, key),
+				mType: mType,
+				help:  fmt.Sprintf("%s metric %s", mType, m.ID),
+			}
+			families[key] = f
+			byName[f.name] = f
+		}
+		f.series = append(f.series, textSeries{labels: formatLabels(m.Labels), metric: m})
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		f := byName[name]
+		fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.mType)
+		sort.SliceStable(f.series, func(i, j int) bool { return f.series[i].less(f.series[j]) })
+		for i, s := range f.series {
+			// Prometheus отклоняет повторяющиеся серии
+			if i > 0 && f.series[i-1].labels == s.labels {
+				continue
+			}
+			var value float64
+			if f.mType == "gauge" {
+				value = *s.metric.Value
+			} else {
+				value = float64(*s.metric.Delta)
+			}
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, s.labels, FormatValue(value))
+		}
+	}
+	return buf.Flush()
+}
+
+// familyName - sanitized name if it isn't used by another family, otherwise name with type
+// and a number if needed
+func familyName(byName map[string]*family, key familyKey) string {
+	if _, ok := byName[key.name]; !ok {
+		return key.name
+	}
+	name := key.name + "_" + key.mType
+	for i := 2; ; i++ {
+		if _, ok := byName[name]; !ok {
+			return name
+		}
+		name = fmt.Sprintf("%s_%s_%d", key.name, key.mType, i)
+	}
+}
+
+// less - series are sorted by labels, series of the same labels by preference of their metric
+func (s textSeries) less(other textSeries) bool {
+	if s.labels != other.labels {
+		return s.labels < other.labels
+	}
+	exact, otherExact := s.metric.ID == SanitizeName(s.metric.ID), other.metric.ID == SanitizeName(other.metric.ID)
+	if exact != otherExact {
+		return exact
+	}
+	return s.metric.ID < other.metric.ID
+}
+
+// SanitizeName - replace symbols which are not allowed in Prometheus metric name with '_'.
+func SanitizeName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// SanitizeLabelName - replace symbols which are not allowed in Prometheus label name with '_'.
+func SanitizeLabelName(name string) string {
+	return strings.ReplaceAll(SanitizeName(name), ":", "_")
+}
+
+// formatLabels - {a="1",b="2"} with sanitized names and escaped values, sorted by name.
+func formatLabels(labels models.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sanitized := make(map[string]string, len(labels))
+	names := make([]string, 0, len(labels))
+	for name, value := range labels {
+		name = SanitizeLabelName(name)
+		if _, ok := sanitized[name]; !ok {
+			names = append(names, name)
+		}
+		sanitized[name] = value
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueReplacer.Replace(sanitized[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// FormatValue - format sample value like Prometheus does.
+func FormatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	help = strings.ReplaceAll(help, `\`, `\\`)
+	return strings.ReplaceAll(help, "\n", `\n`)
+}
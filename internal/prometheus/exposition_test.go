@@ -0,0 +1,111 @@
+package prometheus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestWriteText(t *testing.T) {
+	alloc := 5.5
+	sys := 1e21
+	pollCount := int64(7)
+	sameName := 1.0
+	metrics := []models.Metrics{
+		{ID: "PollCount", MType: "Counter", Delta: &pollCount},
+		{ID: "Alloc", MType: "gauge", Value: &alloc},
+		{ID: "sys.bytes-total", MType: "Gauge", Value: &sys},
+		{ID: "PollCount", MType: "gauge", Value: &sameName},
+		{ID: "Empty", MType: "gauge"},
+		{ID: "Alloc", MType: "gauge", Value: &alloc, Labels: models.Labels{"host": "h\"1", "agent.id": "a"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteText(&buf, metrics))
+	assert.Equal(t, `# HELP Alloc gauge metric Alloc
+# TYPE Alloc gauge
+Alloc 5.5
+Alloc{agent_id="a",host="h\"1"} 5.5
+# HELP PollCount counter metric PollCount
+# TYPE PollCount counter
+PollCount 7
+# HELP PollCount_gauge gauge metric PollCount
+# TYPE PollCount_gauge gauge
+PollCount_gauge 1
+# HELP sys_bytes_total gauge metric sys.bytes-total
+# TYPE sys_bytes_total gauge
+sys_bytes_total 1e+21
+`, buf.String())
+}
+
+func TestWriteTextCollisions(t *testing.T) {
+	one := 1.0
+	two := 2.0
+	count := int64(3)
+
+	t.Run("[Positive] Счётчик не попадает в семейство gauge с тем же именем", func(t *testing.T) {
+		metrics := []models.Metrics{
+			{ID: "x_counter", MType: "gauge", Value: &one},
+			{ID: "x", MType: "gauge", Value: &two, Labels: models.Labels{"a": "1"}},
+			{ID: "x", MType: "counter", Delta: &count},
+		}
+		var buf bytes.Buffer
+		require.NoError(t, WriteText(&buf, metrics))
+		assert.Equal(t, `# HELP x gauge metric x
+# TYPE x gauge
+x{a="1"} 2
+# HELP x_counter gauge metric x_counter
+# TYPE x_counter gauge
+x_counter 1
+# HELP x_counter_2 counter metric x
+# TYPE x_counter_2 counter
+x_counter_2 3
+`, buf.String())
+	})
+
+	t.Run("[Positive] Совпавшие после замены символов серии пишутся один раз", func(t *testing.T) {
+		metrics := []models.Metrics{
+			{ID: "a.b", MType: "gauge", Value: &one},
+			{ID: "a_b", MType: "gauge", Value: &two},
+			{ID: "a-b", MType: "gauge", Value: &one, Labels: models.Labels{"host": "h1"}},
+		}
+		var buf bytes.Buffer
+		require.NoError(t, WriteText(&buf, metrics))
+		assert.Equal(t, `# HELP a_b gauge metric a.b
+# TYPE a_b gauge
+a_b 2
+a_b{host="h1"} 1
+`, buf.String())
+	})
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Alloc", want: "Alloc"},
+		{name: "http.requests-total", want: "http_requests_total"},
+		{name: "1xx", want: "_1xx"},
+		{name: "ns:metric_1", want: "ns:metric_1"},
+		{name: "память", want: "______"},
+		{name: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SanitizeName(tt.name))
+		})
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	assert.Equal(t, "NaN", FormatValue(math.NaN()))
+	assert.Equal(t, "+Inf", FormatValue(math.Inf(1)))
+	assert.Equal(t, "-Inf", FormatValue(math.Inf(-1)))
+	assert.Equal(t, "0.1", FormatValue(0.1))
+}
@@ -0,0 +1,381 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// MaxWriteRequestSize - max size of decoded remote write request.
+const MaxWriteRequestSize = 32 << 20
+
+// SeriesTTL - converter forgets counter series which haven't been received during the time.
+const SeriesTTL = time.Hour
+
+// MetricType - type of metric family from remote write metadata.
+type MetricType int32
+
+const (
+	MetricTypeUnknown        MetricType = 0
+	MetricTypeCounter        MetricType = 1
+	MetricTypeGauge          MetricType = 2
+	MetricTypeHistogram      MetricType = 3
+	MetricTypeGaugeHistogram MetricType = 4
+	MetricTypeSummary        MetricType = 5
+)
+
+// Label - name and value of series label.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample - value of series with timestamp in milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries - labels and samples of one series.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// MetricMetadata - metadata of metric family.
+type MetricMetadata struct {
+	Type       MetricType
+	FamilyName string
+	Help       string
+	Unit       string
+}
+
+// WriteRequest - Prometheus remote write request (prometheus.WriteRequest protobuf message).
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+// DecodeWriteRequest - read snappy compressed protobuf remote write request.
+func DecodeWriteRequest(r io.Reader) (*WriteRequest, error) {
+	compressed, err := io.ReadAll(io.LimitReader(r, MaxWriteRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) > MaxWriteRequestSize {
+		return nil, errors.New("remote write request is too large")
+	}
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxWriteRequestSize {
+		return nil, errors.New("remote write request is too large")
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalWriteRequest(data)
+}
+
+// UnmarshalWriteRequest - parse protobuf remote write request.
+// Exemplars and native histograms are skipped.
+func UnmarshalWriteRequest(data []byte) (*WriteRequest, error) {
+	req := &WriteRequest{}
+	err := parseMessage(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			ts, err := parseTimeSeries(value)
+			if err != nil {
+				return err
+			}
+			req.Timeseries = append(req.Timeseries, ts)
+		case num == 3 && typ == protowire.BytesType:
+			md, err := parseMetadata(value)
+			if err != nil {
+				return err
+			}
+			req.Metadata = append(req.Metadata, md)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't parse remote write request: %w", err)
+	}
+	return req, nil
+}
+
+func parseTimeSeries(data []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := parseMessage(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var label Label
+			err := parseMessage(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if typ != protowire.BytesType {
+					return nil
+				}
+				switch num {
+				case 1:
+					label.Name = string(value)
+				case 2:
+					label.Value = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, label)
+		case num == 2 && typ == protowire.BytesType:
+			var sample Sample
+			err := parseMessage(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					v, _ := protowire.ConsumeFixed64(value)
+					sample.Value = math.Float64frombits(v)
+				case num == 2 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(value)
+					sample.Timestamp = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, sample)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+func parseMetadata(data []byte) (MetricMetadata, error) {
+	var md MetricMetadata
+	err := parseMessage(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(value)
+			md.Type = MetricType(v)
+		case num == 2 && typ == protowire.BytesType:
+			md.FamilyName = string(value)
+		case num == 4 && typ == protowire.BytesType:
+			md.Help = string(value)
+		case num == 5 && typ == protowire.BytesType:
+			md.Unit = string(value)
+		}
+		return nil
+	})
+	return md, err
+}
+
+// parseMessage - call f for every field of protobuf message.
+// For bytes fields value is the content, for other types value is the raw field value.
+func parseMessage(data []byte, f func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var value []byte
+		if typ == protowire.BytesType {
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			value, n = v, m
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value = data[:n]
+		}
+		if err := f(num, typ, value); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// SavedFunc - reports whether counter series is already saved in storage.
+type SavedFunc func(ctx context.Context, m models.Metrics) bool
+
+// Converter - converts remote write series to gauges and counters.
+// Prometheus counters are cumulative, so converter remembers the last value of every series
+// and returns the increase since the previous request as counter delta.
+// Series unknown to converter, but already saved, for example after restart of the server,
+// gets delta 0: its total is already counted, the increase is counted from the next request.
+// Last values are changed by Commit after the metrics are saved, so samples resent after
+// a failed save give the same deltas again.
+// Counter delta is an integer: fractional part of the increase, for example of _sum in seconds,
+// is carried to the next request of the series, so the saved total differs from the real one by less than 1.
+// Series not received during SeriesTTL are forgotten by the next Commit after it, so memory is bounded
+// by series received during the last two TTLs. Forgotten series which come back are handled as after restart.
+type Converter struct {
+	mutex sync.Mutex
+	last  map[string]counterState
+	saved SavedFunc
+	// evicted - time of the last removal of forgotten series
+	evicted time.Time
+}
+
+// counterState - last value of counter series and increase which isn't saved yet
+type counterState struct {
+	value     float64
+	remainder float64
+	seen      time.Time
+}
+
+// Pending - last values of counters converted by Convert, see Converter.Commit.
+type Pending struct {
+	last map[string]counterState
+}
+
+// NewConverter - create new converter, saved may be nil if storage isn't checked.
+func NewConverter(saved SavedFunc) *Converter {
+	return &Converter{last: make(map[string]counterState), saved: saved}
+}
+
+// Convert - take the latest sample of every series. Labels of series are kept, except __name__.
+// Series with COUNTER metadata, histogram and summary series and series with _total suffix are counters,
+// other series are gauges. Stale markers and series without name are skipped.
+// Returned Pending is committed after the metrics are saved.
+func (c *Converter) Convert(ctx context.Context, req *WriteRequest) ([]models.Metrics, Pending) {
+	types := make(map[string]MetricType, len(req.Metadata))
+	for _, md := range req.Metadata {
+		types[md.FamilyName] = md.Type
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result := make([]models.Metrics, 0, len(req.Timeseries))
+	pending := Pending{last: make(map[string]counterState)}
+	for _, ts := range req.Timeseries {
+		name, labels := splitLabels(ts.Labels)
+		if name == "" || len(ts.Samples) == 0 {
+			continue
+		}
+		sample := ts.Samples[0]
+		for _, s := range ts.Samples[1:] {
+			if s.Timestamp >= sample.Timestamp {
+				sample = s
+			}
+		}
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+
+		if !isCounter(name, types) {
+			value := sample.Value
+			result = append(result, models.Metrics{ID: name, MType: "gauge", Value: &value, Labels: labels})
+			continue
+		}
+
+		key := seriesKey(ts.Labels)
+		current := sample.Value
+		m := models.Metrics{ID: name, MType: "counter", Labels: labels}
+		increase := current
+		last, ok := c.last[key]
+		switch {
+		case ok && current >= last.value:
+			increase = current - last.value + last.remainder
+		case ok:
+			// счётчик сброшен: прирост - новое значение
+			increase = current + last.remainder
+		case c.saved != nil && c.saved(ctx, m):
+			increase = 0
+		}
+		whole := math.Floor(increase)
+		pending.last[key] = counterState{value: current, remainder: increase - whole}
+		delta := int64(whole)
+		m.Delta = &delta
+		result = append(result, m)
+	}
+	return result, pending
+}
+
+// Commit - remember last values of saved counters, the next deltas are counted from them.
+func (c *Converter) Commit(pending Pending) {
+	c.commit(pending, time.Now())
+}
+
+func (c *Converter) commit(pending Pending, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, state := range pending.last {
+		state.seen = now
+		c.last[key] = state
+	}
+	// обход всех серий не чаще раза в SeriesTTL
+	if now.Sub(c.evicted) < SeriesTTL {
+		return
+	}
+	for key, state := range c.last {
+		if now.Sub(state.seen) > SeriesTTL {
+			delete(c.last, key)
+		}
+	}
+	c.evicted = now
+}
+
+func isCounter(name string, types map[string]MetricType) bool {
+	if t, ok := types[name]; ok {
+		return t == MetricTypeCounter
+	}
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		t := types[strings.TrimSuffix(name, suffix)]
+		if t == MetricTypeHistogram || t == MetricTypeSummary {
+			return true
+		}
+	}
+	return strings.HasSuffix(name, "_total")
+}
+
+// splitLabels - metric name from __name__ label and other labels.
+func splitLabels(labels []Label) (string, models.Labels) {
+	var name string
+	var result models.Labels
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			continue
+		}
+		if result == nil {
+			result = make(models.Labels, len(labels))
+		}
+		result[l.Name] = l.Value
+	}
+	return name, result
+}
+
+func seriesKey(labels []Label) string {
+	sorted := make([]Label, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var b strings.Builder
+	for _, l := range sorted {
+		b.WriteString(l.Name)
+		b.WriteByte('=')
+		b.WriteString(l.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
@@ -0,0 +1,195 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// marshalWriteRequest - protobuf encoding of the request, is used for tests only.
+func marshalWriteRequest(req *WriteRequest) []byte {
+	var b []byte
+	for _, ts := range req.Timeseries {
+		var series []byte
+		for _, l := range ts.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		for _, s := range ts.Samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+			series = protowire.AppendTag(series, 2, protowire.BytesType)
+			series = protowire.AppendBytes(series, sample)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, series)
+	}
+	for _, md := range req.Metadata {
+		var metadata []byte
+		metadata = protowire.AppendTag(metadata, 1, protowire.VarintType)
+		metadata = protowire.AppendVarint(metadata, uint64(md.Type))
+		metadata = protowire.AppendTag(metadata, 2, protowire.BytesType)
+		metadata = protowire.AppendString(metadata, md.FamilyName)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, metadata)
+	}
+	return b
+}
+
+func series(name string, samples ...Sample) TimeSeries {
+	return TimeSeries{
+		Labels:  []Label{{Name: "__name__", Value: name}, {Name: "job", Value: "node"}},
+		Samples: samples,
+	}
+}
+
+func TestDecodeWriteRequest(t *testing.T) {
+	req := &WriteRequest{
+		Timeseries: []TimeSeries{
+			series("node_load1", Sample{Value: 0.5, Timestamp: 1000}, Sample{Value: 0.7, Timestamp: 2000}),
+			series("http_requests_total", Sample{Value: 10, Timestamp: 1000}),
+		},
+		Metadata: []MetricMetadata{{Type: MetricTypeGauge, FamilyName: "node_load1"}},
+	}
+	body := snappy.Encode(nil, marshalWriteRequest(req))
+
+	decoded, err := DecodeWriteRequest(bytes.NewReader(body))
+	require.NoError(t, err)
+	assert.Equal(t, req, decoded)
+
+	_, err = DecodeWriteRequest(bytes.NewReader([]byte("not snappy")))
+	assert.Error(t, err)
+}
+
+// convertAndCommit - convert request, metrics are saved successfully
+func convertAndCommit(c *Converter, req *WriteRequest) []models.Metrics {
+	metrics, pending := c.Convert(context.Background(), req)
+	c.Commit(pending)
+	return metrics
+}
+
+func TestConverter(t *testing.T) {
+	c := NewConverter(nil)
+	req := &WriteRequest{
+		Timeseries: []TimeSeries{
+			series("node_load1", Sample{Value: 0.5, Timestamp: 2000}, Sample{Value: 0.7, Timestamp: 1000}),
+			series("http_requests_total", Sample{Value: 10, Timestamp: 1000}),
+			series("rpc_duration_seconds_count", Sample{Value: 3, Timestamp: 1000}),
+			series("stale", Sample{Value: math.NaN(), Timestamp: 1000}),
+			{Labels: []Label{{Name: "job", Value: "node"}}, Samples: []Sample{{Value: 1}}},
+		},
+		Metadata: []MetricMetadata{{Type: MetricTypeSummary, FamilyName: "rpc_duration_seconds"}},
+	}
+	metrics := convertAndCommit(c, req)
+	require.Len(t, metrics, 3)
+	assert.Equal(t, "node_load1", metrics[0].ID)
+	assert.Equal(t, "gauge", metrics[0].MType)
+	assert.Equal(t, 0.5, *metrics[0].Value)
+	assert.Equal(t, models.Labels{"job": "node"}, metrics[0].Labels)
+	assert.Equal(t, "counter", metrics[1].MType)
+	assert.Equal(t, int64(10), *metrics[1].Delta)
+	assert.Equal(t, "counter", metrics[2].MType)
+	assert.Equal(t, int64(3), *metrics[2].Delta)
+
+	tests := []struct {
+		name  string
+		value float64
+		delta int64
+	}{
+		{name: "[Positive] Счётчик вырос - передаётся прирост", value: 25, delta: 15},
+		{name: "[Positive] Счётчик не изменился - прирост 0", value: 25, delta: 0},
+		{name: "[Positive] Счётчик сброшен - передаётся новое значение", value: 4, delta: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := convertAndCommit(c, &WriteRequest{Timeseries: []TimeSeries{series("http_requests_total", Sample{Value: tt.value})}})
+			require.Len(t, metrics, 1)
+			assert.Equal(t, tt.delta, *metrics[0].Delta)
+		})
+	}
+}
+
+func TestConverterRestart(t *testing.T) {
+	saved := func(ctx context.Context, m models.Metrics) bool {
+		return m.ID == "http_requests_total"
+	}
+	// новый конвертер после перезапуска сервера, серия уже сохранена
+	c := NewConverter(saved)
+
+	metrics := convertAndCommit(c, &WriteRequest{Timeseries: []TimeSeries{
+		series("http_requests_total", Sample{Value: 100}),
+		series("new_requests_total", Sample{Value: 7}),
+	}})
+	require.Len(t, metrics, 2)
+	assert.Equal(t, int64(0), *metrics[0].Delta, "total of saved series is already counted")
+	assert.Equal(t, int64(7), *metrics[1].Delta, "new series is counted from zero")
+
+	metrics = convertAndCommit(c, &WriteRequest{Timeseries: []TimeSeries{series("http_requests_total", Sample{Value: 110})}})
+	require.Len(t, metrics, 1)
+	assert.Equal(t, int64(10), *metrics[0].Delta)
+}
+
+func TestConverterUncommitted(t *testing.T) {
+	c := NewConverter(nil)
+	convertAndCommit(c, &WriteRequest{Timeseries: []TimeSeries{series("http_requests_total", Sample{Value: 10})}})
+
+	// сохранение не удалось, Prometheus повторяет те же сэмплы
+	req := &WriteRequest{Timeseries: []TimeSeries{series("http_requests_total", Sample{Value: 25})}}
+	metrics, _ := c.Convert(context.Background(), req)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, int64(15), *metrics[0].Delta)
+
+	metrics = convertAndCommit(c, req)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, int64(15), *metrics[0].Delta, "increase isn't lost after failed save")
+}
+
+func TestConverterFraction(t *testing.T) {
+	c := NewConverter(nil)
+	var total int64
+	for _, value := range []float64{0.25, 0.5, 1.25, 2, 2.75} {
+		metrics := convertAndCommit(c, &WriteRequest{Timeseries: []TimeSeries{series("rpc_duration_seconds_total", Sample{Value: value})}})
+		require.Len(t, metrics, 1)
+		total += *metrics[0].Delta
+	}
+	// дробная часть прироста переносится, сумма отстаёт от значения меньше чем на 1
+	assert.Equal(t, int64(2), total)
+}
+
+func TestConverterEviction(t *testing.T) {
+	c := NewConverter(nil)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	convert := func(name string, value float64, now time.Time) int64 {
+		metrics, pending := c.Convert(context.Background(), &WriteRequest{Timeseries: []TimeSeries{series(name, Sample{Value: value})}})
+		c.commit(pending, now)
+		return *metrics[0].Delta
+	}
+
+	convert("old_total", 10, start)
+	convert("new_total", 1, start)
+	convert("new_total", 2, start.Add(SeriesTTL))
+	require.Len(t, c.last, 2)
+
+	convert("new_total", 3, start.Add(2*SeriesTTL))
+	assert.Len(t, c.last, 1, "series not received during TTL is forgotten")
+	_, ok := c.last[seriesKey(series("new_total").Labels)]
+	assert.True(t, ok)
+	assert.Equal(t, int64(20), convert("old_total", 20, start.Add(2*SeriesTTL+time.Minute)), "forgotten series is counted as new")
+}
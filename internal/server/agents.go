@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+const (
+	// UpMetric - synthetic gauge with agent state: 1 - agent reports, 0 - agent is stale.
+	UpMetric = "up"
+
+	defaultAgentReportInterval = 10 * time.Second
+	defaultAgentStaleAfter     = 3
+)
+
+// AgentState - liveness state of agent.
+type AgentState string
+
+const (
+	AgentUp    AgentState = "up"
+	AgentStale AgentState = "stale"
+)
+
+// Agent - agent which sends metrics to the server.
+type Agent struct {
+	ID       string     `json:"id"`
+	Address  string     `json:"address"`
+	LastSeen time.Time  `json:"last_seen"`
+	State    AgentState `json:"state"`
+}
+
+// Agents - registry of agents, agent is identified by instance label.
+// Agent becomes stale if it hasn't sent metrics during staleAfter report intervals.
+type Agents struct {
+	mutex          sync.RWMutex
+	agents         map[string]*Agent
+	reportInterval time.Duration
+	staleAfter     int
+}
+
+// NewAgents - create empty registry. Zero values are replaced with defaults.
+func NewAgents(reportInterval time.Duration, staleAfter int) *Agents {
+	if reportInterval <= 0 {
+		reportInterval = defaultAgentReportInterval
+	}
+	if staleAfter <= 0 {
+		staleAfter = defaultAgentStaleAfter
+	}
+	return &Agents{
+		agents:         make(map[string]*Agent),
+		reportInterval: reportInterval,
+		staleAfter:     staleAfter,
+	}
+}
+
+// Seen - agent sent metrics at the time from the address.
+func (a *Agents) Seen(id, address string, t time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	agent, ok := a.agents[id]
+	if !ok {
+		agent = &Agent{ID: id}
+		a.agents[id] = agent
+	}
+	agent.Address = address
+	if t.After(agent.LastSeen) {
+		agent.LastSeen = t
+	}
+}
+
+// Restore - add agents of saved up series, which aren't in the registry, seen at the time.
+// Agent which doesn't report after restart of the server becomes stale and gets up 0.
+// Only series with the single instance label are written by the server, other up series
+// (e.g. sent by remote write with job label) aren't agents.
+func (a *Agents) Restore(series []client.Metrics, t time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, m := range series {
+		id := m.Labels[client.InstanceLabel]
+		if m.ID != UpMetric || id == "" || len(m.Labels) != 1 {
+			continue
+		}
+		if _, ok := a.agents[id]; !ok {
+			a.agents[id] = &Agent{ID: id, LastSeen: t}
+		}
+	}
+}
+
+// List - copy of all agents with state at the time, sorted by id.
+func (a *Agents) List(now time.Time) []Agent {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	staleAfter := time.Duration(a.staleAfter) * a.reportInterval
+	result := make([]Agent, 0, len(a.agents))
+	for _, agent := range a.agents {
+		item := *agent
+		item.State = AgentUp
+		if now.Sub(item.LastSeen) > staleAfter {
+			item.State = AgentStale
+		}
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// UpMetrics - up gauge for every agent.
+func (a *Agents) UpMetrics(now time.Time) []client.Metrics {
+	agents := a.List(now)
+	result := make([]client.Metrics, 0, len(agents))
+	for _, agent := range agents {
+		var value float64
+		if agent.State == AgentUp {
+			value = 1
+		}
+		result = append(result, client.Metrics{
+			ID:     UpMetric,
+			MType:  "gauge",
+			Value:  &value,
+			Labels: client.Labels{client.InstanceLabel: agent.ID},
+		})
+	}
+	return result
+}
+
+// Run - save up metrics every report interval until ctx is done.
+func (a *Agents) Run(ctx context.Context, save func(ctx context.Context, metrics []client.Metrics) error) {
+	ticker := time.NewTicker(a.reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := save(ctx, a.UpMetrics(time.Now())); err != nil {
+				log.Println("Can't save up metrics: ", err)
+			}
+		}
+	}
+}
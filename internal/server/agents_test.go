@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestAgentsState(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	agents := NewAgents(10*time.Second, 3)
+	agents.Seen("host1", "10.0.0.1", now)
+	agents.Seen("host2", "10.0.0.2", now.Add(-31*time.Second))
+	agents.Seen("host3", "10.0.0.3", now.Add(-30*time.Second))
+
+	list := agents.List(now)
+	require.Len(t, list, 3)
+	assert.Equal(t, AgentUp, list[0].State)
+	assert.Equal(t, AgentStale, list[1].State)
+	assert.Equal(t, AgentUp, list[2].State)
+
+	up := agents.UpMetrics(now)
+	require.Len(t, up, 3)
+	assert.Equal(t, `up{instance="host1"}`, up[0].Key())
+	assert.Equal(t, float64(1), *up[0].Value)
+	assert.Equal(t, float64(0), *up[1].Value)
+}
+
+func TestWatchAgents(t *testing.T) {
+	storage := Storage{
+		Metrics: make(map[string]client.Metrics, 10),
+	}
+	rg := NewRouterGroup(nil, &storage, "")
+	agents := NewAgents(10*time.Millisecond, 1)
+	rg.SetAgents(agents)
+	agents.Seen("host1", "", time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rg.WatchAgents(ctx)
+
+	assert.Eventually(t, func() bool {
+		metrics, _ := storage.GetMetrics(ctx)
+		for _, m := range metrics {
+			if m.Key() == `up{instance="host1"}` && *m.Value == 0 {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 5*time.Millisecond, "agent should become stale")
+}
+
+func TestWatchAgentsRestore(t *testing.T) {
+	storage := Storage{
+		Metrics: make(map[string]client.Metrics, 10),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// up сохранён до перезапуска сервера, агент больше не присылает метрики
+	up := 1.0
+	require.NoError(t, storage.UpdateMetric(ctx, client.Metrics{
+		ID: UpMetric, MType: "gauge", Value: &up, Labels: client.Labels{client.InstanceLabel: "host1"},
+	}))
+
+	rg := NewRouterGroup(nil, &storage, "")
+	agents := NewAgents(10*time.Millisecond, 1)
+	rg.SetAgents(agents)
+	go rg.WatchAgents(ctx)
+
+	assert.Eventually(t, func() bool {
+		m, err := storage.GetMetricByType(ctx, "gauge", UpMetric, client.Labels{client.InstanceLabel: "host1"})
+		return err == nil && *m.Value == 0
+	}, time.Second, 5*time.Millisecond, "restored agent should become stale")
+	list := agents.List(time.Now())
+	require.Len(t, list, 1)
+	assert.Equal(t, "host1", list[0].ID)
+}
+
+func TestAgentsRestore(t *testing.T) {
+	up := 1.0
+	now := time.Now()
+	agents := NewAgents(10*time.Second, 3)
+	agents.Restore([]client.Metrics{
+		{ID: UpMetric, MType: "gauge", Value: &up, Labels: client.Labels{client.InstanceLabel: "host1"}},
+		// up{instance,job} получен через remote write, это не агент
+		{ID: UpMetric, MType: "gauge", Value: &up, Labels: client.Labels{client.InstanceLabel: "node:9100", "job": "node"}},
+		{ID: UpMetric, MType: "gauge", Value: &up},
+		{ID: "Alloc", MType: "gauge", Value: &up, Labels: client.Labels{client.InstanceLabel: "host2"}},
+	}, now)
+
+	list := agents.List(now)
+	require.Len(t, list, 1)
+	assert.Equal(t, "host1", list[0].ID)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/alerting"
+)
+
+// App - server application: http router, storage and background jobs
+type App struct {
+	cfg        *Config
+	repo       Repository
+	router     *gin.Engine
+	rg         *RouterGroup
+	alerts     *alerting.Engine
+	dispatcher *alerting.Dispatcher
+}
+
+// NewApp - create storage selected by config, alert engine and routes
+func NewApp(ctx context.Context, cfg *Config) (*App, error) {
+	repo, err := NewRepository(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	app := &App{
+		cfg:  cfg,
+		repo: repo,
+	}
+
+	if cfg.RulesFile != "" {
+		rulesFile, err := alerting.LoadRulesFile(cfg.RulesFile)
+		if err != nil {
+			repo.Close()
+			return nil, err
+		}
+		notifiers, err := rulesFile.Notifiers()
+		if err != nil {
+			repo.Close()
+			return nil, err
+		}
+		app.alerts = alerting.NewEngine(rulesFile.Rules, repo, cfg.AlertInterval)
+		if len(notifiers) > 0 {
+			app.dispatcher = alerting.NewDispatcher(notifiers)
+			app.alerts.OnChange(app.dispatcher.Dispatch)
+		}
+		log.Printf("Loaded %d alert rules, %d notification channels", len(rulesFile.Rules), len(notifiers))
+	}
+
+	app.router = gin.New()
+	app.router.RedirectTrailingSlash = false
+	// gin.Context передаётся в хранилище как context.Context: отмена запроса клиентом прерывает запросы к БД
+	app.router.ContextWithFallback = true
+	app.rg = NewRouterGroup(&app.router.RouterGroup, repo, cfg.Key)
+	app.rg.SetAlerts(app.alerts)
+	app.rg.SetAgents(NewAgents(cfg.AgentReportInterval, cfg.AgentStaleAfter))
+	app.rg.Routes()
+
+	return app, nil
+}
+
+// Router - gin engine for registration of additional routes
+func (a *App) Router() *gin.Engine {
+	return a.router
+}
+
+// Serve - serve requests from listener until ctx is done.
+// Then new connections aren't accepted, in-flight requests are drained during ShutdownTimeout,
+// background jobs are stopped and storage is closed: file storage writes the final snapshot,
+// database closes connections pool.
+func (a *App) Serve(ctx context.Context, ln net.Listener) error {
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	a.runJobs(jobsCtx, &jobs)
+
+	srv := &http.Server{Handler: a.router}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		log.Println("Shutdown server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+		err = srv.Shutdown(shutdownCtx)
+		cancel()
+		if err != nil {
+			log.Println("Shutdown: ", err)
+			srv.Close()
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	stopJobs()
+	jobs.Wait()
+	if closeErr := a.repo.Close(); closeErr != nil {
+		log.Println("Close storage: ", closeErr)
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// runJobs - start store ticker, history retention, alerts and agents watcher
+func (a *App) runJobs(ctx context.Context, jobs *sync.WaitGroup) {
+	run := func(job func()) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job()
+		}()
+	}
+
+	if file, ok := a.repo.(*Storage); ok && a.cfg.StoreInterval > 0 {
+		run(func() {
+			every(ctx, a.cfg.StoreInterval, func() {
+				log.Println("Write data in file")
+				if err := file.SaveMetricInFile(); err != nil {
+					log.Println(err)
+				}
+			})
+		})
+	}
+	if a.cfg.HistoryRetention > 0 {
+		run(func() {
+			every(ctx, time.Minute, func() {
+				deleted, err := a.repo.DeleteHistory(ctx, time.Now().Add(-a.cfg.HistoryRetention))
+				if err != nil {
+					log.Println(err)
+					return
+				}
+				log.Printf("Deleted %d history samples", deleted)
+			})
+		})
+	}
+	if a.alerts != nil {
+		run(func() { a.alerts.Run(ctx) })
+	}
+	if a.dispatcher != nil {
+		run(func() { a.dispatcher.Run(ctx) })
+	}
+	run(func() { a.rg.WatchAgents(ctx) })
+}
+
+// every - call f every interval until ctx is done
+func every(ctx context.Context, interval time.Duration, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
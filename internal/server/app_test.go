@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppShutdown(t *testing.T) {
+	cfg := &Config{
+		StoreFile:       filepath.Join(t.TempDir(), "metrics.json"),
+		StoreInterval:   time.Hour,
+		ShutdownTimeout: 5 * time.Second,
+	}
+	app, err := NewApp(context.Background(), cfg)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(ctx, ln)
+	}()
+
+	url := "http://" + ln.Addr().String()
+	for _, path := range []string{"/update/counter/PollCount/2", "/update/counter/PollCount/3", "/update/gauge/Alloc/1.5"} {
+		resp, err := http.Post(url+path, "text/plain", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	resp, err := http.Post(url+"/updates/", "application/json", strings.NewReader(`[{"id":"Alloc","type":"gauge","value":2.5}]`))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// остановка до срабатывания тикера - последний снимок пишется при завершении
+	cancel()
+	select {
+	case err = <-served:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server isn't stopped")
+	}
+
+	snapshot, err := ReadSnapshot(cfg.StoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *snapshot.Metrics["PollCount"].Delta)
+	assert.Equal(t, 2.5, *snapshot.Metrics["Alloc"].Value)
+
+	_, err = http.Post(url+"/update/counter/PollCount/1", "text/plain", nil)
+	assert.Error(t, err, "server must not accept requests after shutdown")
+}
+
+func TestAppDrainsRequests(t *testing.T) {
+	cfg := &Config{
+		StoreFile:       filepath.Join(t.TempDir(), "metrics.json"),
+		ShutdownTimeout: 5 * time.Second,
+	}
+	app, err := NewApp(context.Background(), cfg)
+	require.NoError(t, err)
+	started := make(chan struct{})
+	app.Router().GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(ctx, ln)
+	}()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	// запрос, принятый до остановки, завершается
+	assert.Equal(t, http.StatusOK, <-status)
+	require.NoError(t, <-served)
+}
@@ -0,0 +1,49 @@
+package server
+
+import "sync"
+
+// groupCommit - makes updates durable in groups.
+// Writer waits until the version of its update is flushed. If nobody flushes now, the writer becomes
+// the leader and flushes all updates applied so far, the others wait for it, so concurrent writers
+// share one fsync or one snapshot.
+type groupCommit struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	flushing bool
+	durable  uint64
+	flushes  uint64
+	// flush - make all applied updates durable, returns version of the last flushed update
+	flush func() (uint64, error)
+}
+
+func newGroupCommit(flush func() (uint64, error)) *groupCommit {
+	gc := &groupCommit{flush: flush}
+	gc.cond = sync.NewCond(&gc.mutex)
+	return gc
+}
+
+// wait - return when update with the version is durable
+func (gc *groupCommit) wait(version uint64) error {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+	for gc.durable < version {
+		if gc.flushing {
+			gc.cond.Wait()
+			continue
+		}
+		gc.flushing = true
+		gc.mutex.Unlock()
+		durable, err := gc.flush()
+		gc.mutex.Lock()
+		gc.flushing = false
+		gc.flushes++
+		if err == nil && durable > gc.durable {
+			gc.durable = durable
+		}
+		gc.cond.Broadcast()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
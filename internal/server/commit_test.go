@@ -0,0 +1,203 @@
+package server
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestSyncStore(t *testing.T) {
+	cfg := &Config{
+		StoreFile: filepath.Join(t.TempDir(), "metrics.json"),
+		Restore:   true,
+		StoreWAL:  true,
+	}
+	storage, err := NewStorages(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, storage.wal, "WAL isn't used in synchronous mode")
+
+	value := 1.5
+	require.NoError(t, storage.SaveGaugeMetric(&client.Metrics{ID: "Alloc", MType: "gauge", Value: &value}))
+	// снимок записан до возврата из метода
+	snapshot, err := ReadSnapshot(cfg.StoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, value, *snapshot.Metrics["Alloc"].Value)
+
+	const writers = 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, storage.SaveMetrics(walBatch("PollCount", 1)))
+		}()
+	}
+	wg.Wait()
+
+	snapshot, err = ReadSnapshot(cfg.StoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers), *snapshot.Metrics["PollCount"].Delta)
+}
+
+func TestSyncStoreGroupCommit(t *testing.T) {
+	cfg := &Config{StoreFile: filepath.Join(t.TempDir(), "metrics.json")}
+	storage, err := NewStorages(cfg)
+	require.NoError(t, err)
+
+	// первый flush ждёт, пока остальные писатели применят обновления
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var flushes int
+	storage.commit.flush = func() (uint64, error) {
+		if flushes++; flushes == 1 {
+			close(started)
+			<-release
+		}
+		return storage.snapshot()
+	}
+
+	const writers = 20
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, storage.SaveMetrics(walBatch("PollCount", 1)))
+	}()
+	<-started
+	for i := 1; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, storage.SaveMetrics(walBatch("PollCount", 1)))
+		}()
+	}
+	require.Eventually(t, func() bool {
+		storage.mutex.Lock()
+		defer storage.mutex.Unlock()
+		return storage.version == writers
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, flushes, "updates applied during flush are written by one snapshot")
+	snapshot, err := ReadSnapshot(cfg.StoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers), *snapshot.Metrics["PollCount"].Delta)
+}
+
+func TestSyncStoreRecoversWAL(t *testing.T) {
+	walCfg := &Config{
+		StoreFile:     filepath.Join(t.TempDir(), "metrics.json"),
+		Restore:       true,
+		StoreWAL:      true,
+		StoreInterval: time.Hour,
+	}
+	storage, err := NewStorages(walCfg)
+	require.NoError(t, err)
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 2)))
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 3)))
+	// сбой: снимок не записан, обновления только в журнале
+
+	syncCfg := *walCfg
+	syncCfg.StoreInterval = 0
+	storage, err = NewStorages(&syncCfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *storage.Metrics["PollCount"].Delta)
+	snapshot, err := ReadSnapshot(syncCfg.StoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *snapshot.Metrics["PollCount"].Delta)
+
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 1)))
+	// журнал очищен: после возврата в режим WAL обновления не применяются повторно
+	storage, err = NewStorages(walCfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), *storage.Metrics["PollCount"].Delta)
+}
+
+func TestIntervalStoreRecoversWAL(t *testing.T) {
+	walCfg := &Config{
+		StoreFile:     filepath.Join(t.TempDir(), "metrics.json"),
+		Restore:       true,
+		StoreWAL:      true,
+		StoreInterval: time.Hour,
+	}
+	storage, err := NewStorages(walCfg)
+	require.NoError(t, err)
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 2)))
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 3)))
+	// сбой: снимок не записан, обновления только в журнале
+
+	noWALCfg := *walCfg
+	noWALCfg.StoreWAL = false
+	storage, err = NewStorages(&noWALCfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *storage.Metrics["PollCount"].Delta)
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 1)))
+	require.NoError(t, storage.SaveMetricInFile())
+
+	// журнал очищен: после возврата в режим WAL обновления не применяются повторно
+	storage, err = NewStorages(walCfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), *storage.Metrics["PollCount"].Delta)
+}
+
+func TestWALGroupCommit(t *testing.T) {
+	cfg := &Config{
+		StoreFile:     filepath.Join(t.TempDir(), "metrics.json"),
+		Restore:       true,
+		StoreWAL:      true,
+		StoreInterval: time.Hour,
+	}
+	storage, err := NewStorages(cfg)
+	require.NoError(t, err)
+
+	const writers = 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, storage.SaveMetrics(walBatch("PollCount", 1)))
+		}()
+	}
+	wg.Wait()
+
+	storage, err = NewStorages(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers), *storage.Metrics["PollCount"].Delta)
+}
+
+func TestGroupCommit(t *testing.T) {
+	var mutex sync.Mutex
+	var version uint64
+	gc := newGroupCommit(func() (uint64, error) {
+		mutex.Lock()
+		v := version
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return v, nil
+	})
+
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mutex.Lock()
+			version++
+			v := version
+			mutex.Unlock()
+			assert.NoError(t, gc.wait(v))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(writers), gc.durable)
+	assert.Less(t, gc.flushes, uint64(writers))
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/caarlos0/env/v6"
 	flag "github.com/spf13/pflag"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/db"
 )
 
 var (
@@ -15,8 +17,31 @@ var (
 	StoreFile     = flag.StringP("f", "f", "/tmp/devops-metrics-db.json", "help message for StoreFile")
 	StoreInterval = flag.DurationP("i", "i", 300*time.Second, "help message for StoreInterval")
 	Restore       = flag.BoolP("r", "r", true, "help message for Restore")
+	StoreWAL      = flag.Bool("wal", true, "help message for StoreWAL")
+	SnapshotKeep  = flag.Int("snapshot-keep", 0, "help message for SnapshotKeep")
+	Shutdown      = flag.Duration("shutdown-timeout", 10*time.Second, "help message for ShutdownTimeout")
 	Key           = flag.StringP("k", "k", "", "help message for KEY")
 	DSN           = flag.StringP("d", "d", "", "help message for DSN")
+	KVFile        = flag.String("kv-file", "", "help message for KVFile")
+	RulesFile     = flag.String("rules", "", "help message for RulesFile")
+	AlertInterval = flag.Duration("alert-interval", 15*time.Second, "help message for AlertInterval")
+	HistorySize   = flag.Int("history-size", 1000, "help message for HistorySize")
+	HistoryRetain = flag.Duration("history-retention", 24*time.Hour, "help message for HistoryRetention")
+	AgentInterval = flag.Duration("agent-report-interval", 10*time.Second, "help message for AgentReportInterval")
+	StaleAfter    = flag.Int("agent-stale-after", 3, "help message for AgentStaleAfter")
+	DBMaxOpen     = flag.Int("db-max-open-conns", 20, "help message for DBMaxOpenConns")
+	DBMaxIdle     = flag.Int("db-max-idle-conns", 10, "help message for DBMaxIdleConns")
+	DBLifetime    = flag.Duration("db-conn-max-lifetime", 30*time.Minute, "help message for DBConnMaxLifetime")
+	DBStatement   = flag.Duration("db-statement-timeout", 30*time.Second, "help message for DBStatementTimeout")
+	DBQuery       = flag.Duration("db-query-timeout", 10*time.Second, "help message for DBQueryTimeout")
+	DBRetries     = flag.Int("db-retry-attempts", 3, "help message for DBRetryAttempts")
+	DBRetryDelay  = flag.Duration("db-retry-delay", 100*time.Millisecond, "help message for DBRetryDelay")
+	DBRetryMax    = flag.Duration("db-retry-max-delay", 2*time.Second, "help message for DBRetryMaxDelay")
+	DBConnect     = flag.Duration("db-connect-timeout", 30*time.Second, "help message for DBConnectTimeout")
+	DBCopy        = flag.Int("db-copy-threshold", 1000, "help message for DBCopyThreshold")
+	DBBuffer      = flag.Int("db-buffer-size", 0, "help message for DBBufferSize")
+	DBFlush       = flag.Duration("db-flush-interval", time.Second, "help message for DBFlushInterval")
+	DBCache       = flag.Int("db-cache-size", 0, "help message for DBCacheSize")
 )
 
 type Config struct {
@@ -26,6 +51,68 @@ type Config struct {
 	Restore       bool          `env:"RESTORE"`
 	Key           string        `env:"KEY"`
 	DSN           string        `env:"DATABASE_DSN"`
+	// KVFile - файл встроенного key-value хранилища, используется если DSN не задан
+	KVFile        string        `env:"KV_FILE"`
+	RulesFile     string        `env:"ALERT_RULES_FILE"`
+	AlertInterval time.Duration `env:"ALERT_EVALUATION_INTERVAL"`
+	// HistorySize - количество значений каждой метрики в памяти, 0 - история отключена
+	HistorySize int `env:"HISTORY_SIZE"`
+	// HistoryRetention - сколько хранить историю в памяти и в БД
+	HistoryRetention time.Duration `env:"HISTORY_RETENTION"`
+	// AgentReportInterval - как часто агенты отправляют метрики
+	AgentReportInterval time.Duration `env:"AGENT_REPORT_INTERVAL"`
+	// AgentStaleAfter - через сколько пропущенных интервалов агент считается недоступным
+	AgentStaleAfter int `env:"AGENT_STALE_AFTER"`
+	// StoreWAL - журнал обновлений рядом с StoreFile, записывается до ответа агенту
+	StoreWAL bool `env:"STORE_WAL"`
+	// SnapshotKeep - сколько предыдущих снимков StoreFile хранить для отката
+	SnapshotKeep int `env:"STORE_SNAPSHOT_KEEP"`
+	// ShutdownTimeout - сколько ждать завершения обрабатываемых запросов при остановке
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	// DBMaxOpenConns - максимум открытых соединений с БД, 0 - без ограничения
+	DBMaxOpenConns int `env:"DB_MAX_OPEN_CONNS"`
+	// DBMaxIdleConns - максимум простаивающих соединений с БД
+	DBMaxIdleConns int `env:"DB_MAX_IDLE_CONNS"`
+	// DBConnMaxLifetime - время жизни соединения с БД, 0 - без ограничения
+	DBConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME"`
+	// DBStatementTimeout - statement_timeout соединений, 0 - значение сервера БД
+	DBStatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT"`
+	// DBQueryTimeout - дедлайн каждого запроса к БД, 0 - только дедлайн запроса к серверу
+	DBQueryTimeout time.Duration `env:"DB_QUERY_TIMEOUT"`
+	// DBRetryAttempts - сколько раз выполнять запрос при временной ошибке БД, 1 - без повторов
+	DBRetryAttempts int `env:"DB_RETRY_ATTEMPTS"`
+	// DBRetryDelay - пауза перед первым повтором, удваивается до DBRetryMaxDelay
+	DBRetryDelay    time.Duration `env:"DB_RETRY_DELAY"`
+	DBRetryMaxDelay time.Duration `env:"DB_RETRY_MAX_DELAY"`
+	// DBConnectTimeout - сколько ждать доступности БД при запуске
+	DBConnectTimeout time.Duration `env:"DB_CONNECT_TIMEOUT"`
+	// DBCopyThreshold - batch от этого размера загружается в БД через COPY, 0 - COPY не используется
+	DBCopyThreshold int `env:"DB_COPY_THRESHOLD"`
+	// DBBufferSize - сколько серий из одиночных обновлений копить перед записью в БД, 0 - писать сразу
+	DBBufferSize int `env:"DB_BUFFER_SIZE"`
+	// DBFlushInterval - как часто записывать буфер в БД, если он не заполнен
+	DBFlushInterval time.Duration `env:"DB_FLUSH_INTERVAL"`
+	// DBCacheSize - сколько серий хранить в кэше чтения, 0 - кэш отключён.
+	// Включать, только если в БД пишет один сервер
+	DBCacheSize int `env:"DB_CACHE_SIZE"`
+}
+
+// DBOptions - connection pool and timeouts of Postgres storage
+func (cfg *Config) DBOptions() db.Options {
+	return db.Options{
+		MaxOpenConns:     cfg.DBMaxOpenConns,
+		MaxIdleConns:     cfg.DBMaxIdleConns,
+		ConnMaxLifetime:  cfg.DBConnMaxLifetime,
+		StatementTimeout: cfg.DBStatementTimeout,
+		QueryTimeout:     cfg.DBQueryTimeout,
+		RetryAttempts:    cfg.DBRetryAttempts,
+		RetryDelay:       cfg.DBRetryDelay,
+		RetryMaxDelay:    cfg.DBRetryMaxDelay,
+		CopyThreshold:    cfg.DBCopyThreshold,
+		BufferSize:       cfg.DBBufferSize,
+		FlushInterval:    cfg.DBFlushInterval,
+		CacheSize:        cfg.DBCacheSize,
+	}
 }
 
 func NewConfig() *Config {
@@ -41,7 +128,8 @@ func NewConfig() *Config {
 	if cfg.Address == "" {
 		cfg.Address = *Address
 	}
-	if cfg.StoreInterval == 0 {
+	// STORE_INTERVAL=0 - синхронный режим, поэтому проверяем наличие переменной, а не значение
+	if _, ok := os.LookupEnv("STORE_INTERVAL"); !ok {
 		cfg.StoreInterval = *StoreInterval
 	}
 	if cfg.StoreFile == "" {
@@ -53,9 +141,83 @@ func NewConfig() *Config {
 	if cfg.DSN == "" {
 		cfg.DSN = *DSN
 	}
+	if cfg.KVFile == "" {
+		cfg.KVFile = *KVFile
+	}
+	if cfg.RulesFile == "" {
+		cfg.RulesFile = *RulesFile
+	}
+	if cfg.AlertInterval == 0 {
+		cfg.AlertInterval = *AlertInterval
+	}
+	if os.Getenv("HISTORY_SIZE") == "" {
+		cfg.HistorySize = *HistorySize
+	}
+	// 0 хранит историю без ограничения по времени
+	if _, ok := os.LookupEnv("HISTORY_RETENTION"); !ok {
+		cfg.HistoryRetention = *HistoryRetain
+	}
+	if cfg.AgentReportInterval == 0 {
+		cfg.AgentReportInterval = *AgentInterval
+	}
+	if cfg.AgentStaleAfter == 0 {
+		cfg.AgentStaleAfter = *StaleAfter
+	}
 	if os.Getenv("RESTORE") == "" {
 		cfg.Restore = *Restore
 	}
+	if os.Getenv("STORE_WAL") == "" {
+		cfg.StoreWAL = *StoreWAL
+	}
+	if cfg.SnapshotKeep == 0 {
+		cfg.SnapshotKeep = *SnapshotKeep
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = *Shutdown
+	}
+	// 0 отключает ограничение, поэтому проверяем наличие переменных
+	if _, ok := os.LookupEnv("DB_MAX_OPEN_CONNS"); !ok {
+		cfg.DBMaxOpenConns = *DBMaxOpen
+	}
+	if _, ok := os.LookupEnv("DB_MAX_IDLE_CONNS"); !ok {
+		cfg.DBMaxIdleConns = *DBMaxIdle
+	}
+	if _, ok := os.LookupEnv("DB_CONN_MAX_LIFETIME"); !ok {
+		cfg.DBConnMaxLifetime = *DBLifetime
+	}
+	if _, ok := os.LookupEnv("DB_STATEMENT_TIMEOUT"); !ok {
+		cfg.DBStatementTimeout = *DBStatement
+	}
+	if _, ok := os.LookupEnv("DB_QUERY_TIMEOUT"); !ok {
+		cfg.DBQueryTimeout = *DBQuery
+	}
+	// 0 отключает повторы
+	if _, ok := os.LookupEnv("DB_RETRY_ATTEMPTS"); !ok {
+		cfg.DBRetryAttempts = *DBRetries
+	}
+	if cfg.DBRetryDelay == 0 {
+		cfg.DBRetryDelay = *DBRetryDelay
+	}
+	if cfg.DBRetryMaxDelay == 0 {
+		cfg.DBRetryMaxDelay = *DBRetryMax
+	}
+	if cfg.DBConnectTimeout == 0 {
+		cfg.DBConnectTimeout = *DBConnect
+	}
+	if _, ok := os.LookupEnv("DB_COPY_THRESHOLD"); !ok {
+		cfg.DBCopyThreshold = *DBCopy
+	}
+	// 0 отключает буфер записи
+	if _, ok := os.LookupEnv("DB_BUFFER_SIZE"); !ok {
+		cfg.DBBufferSize = *DBBuffer
+	}
+	if cfg.DBFlushInterval == 0 {
+		cfg.DBFlushInterval = *DBFlush
+	}
+	// 0 отключает кэш чтения
+	if _, ok := os.LookupEnv("DB_CACHE_SIZE"); !ok {
+		cfg.DBCacheSize = *DBCache
+	}
 
 	log.Println(cfg.Address)
 	log.Println(cfg)
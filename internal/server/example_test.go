@@ -3,7 +3,6 @@ package server_test
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/iddanilov/metricsAndAlerting/internal/db"
 	client "github.com/iddanilov/metricsAndAlerting/internal/models"
 	"github.com/iddanilov/metricsAndAlerting/internal/server"
 	"net/http"
@@ -22,14 +21,10 @@ func ExampleRouterGroup_UpdateMetricByPath() {
 	storage := server.Storage{
 		Metrics: make(map[string]client.Metrics, 10),
 	}
-	db, err := db.NewDB("host=localhost user=admin password=password dbname=postgres port=6432 sslmode=disable")
-	if err != nil {
-		panic(err)
-	}
 
 	r := gin.New()
 	r.RedirectTrailingSlash = false
-	rg := server.NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL", db, false)
+	rg := server.NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL")
 	rg.Routes()
 
 	// запускаем сервер
@@ -61,14 +56,9 @@ func ExampleRouterGroup_GetMetric() {
 	}
 	storage.Metrics[metricResult.ID] = client.Metrics{ID: metricResult.ID, MType: metricResult.MType, Value: metricResult.Value, Delta: metricResult.Delta}
 
-	db, err := db.NewDB("host=localhost user=admin password=password dbname=postgres port=6432 sslmode=disable")
-	if err != nil {
-		panic(err)
-	}
-
 	r := gin.New()
 	r.RedirectTrailingSlash = false
-	rg := server.NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL", db, true)
+	rg := server.NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL")
 	rg.Routes()
 
 	// запускаем сервер
@@ -102,7 +92,7 @@ func ExampleRouterGroup_GetMetric() {
 //
 //	r := gin.New()
 //	r.RedirectTrailingSlash = false
-//	rg := server.NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL", db, false)
+//	rg := server.NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL")
 //	rg.Routes()
 //
 //	// запускаем сервер
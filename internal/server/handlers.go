@@ -3,6 +3,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
@@ -11,33 +12,50 @@ import (
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/iddanilov/metricsAndAlerting/internal/db"
+	"github.com/iddanilov/metricsAndAlerting/internal/alerting"
 	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
 	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+	"github.com/iddanilov/metricsAndAlerting/internal/prometheus"
 )
 
 type RouterGroup struct {
-	rg    *gin.RouterGroup
-	s     *Storage
-	key   string
-	db    *db.DB
-	useDB bool
+	rg          *gin.RouterGroup
+	repo        Repository
+	key         string
+	alerts      *alerting.Engine
+	remoteWrite *prometheus.Converter
+	agents      *Agents
 }
 
-// NewRouterGroup - create new gin route group
-func NewRouterGroup(rg *gin.RouterGroup, s *Storage, key string, db *db.DB, useDB bool) *RouterGroup {
+// NewRouterGroup - create new gin route group with metrics storage
+func NewRouterGroup(rg *gin.RouterGroup, repo Repository, key string) *RouterGroup {
 	return &RouterGroup{
-		rg:    rg,
-		s:     s,
-		key:   key,
-		db:    db,
-		useDB: useDB,
+		rg:          rg,
+		repo:        repo,
+		key:         key,
+		remoteWrite: prometheus.NewConverter(savedCounter(repo)),
+		agents:      NewAgents(0, 0),
 	}
 }
 
+// savedCounter - counter series is in the repository
+func savedCounter(repo Repository) prometheus.SavedFunc {
+	return func(ctx context.Context, m client.Metrics) bool {
+		_, err := repo.GetMetricByType(ctx, "counter", m.ID, m.Labels)
+		return err == nil
+	}
+}
+
+// SetAlerts - set alert engine for /alerts endpoint
+func (h *RouterGroup) SetAlerts(alerts *alerting.Engine) {
+	h.alerts = alerts
+}
+
 func (h *RouterGroup) Routes() {
 	group := h.rg.Group("/")
 	group.Use()
@@ -48,19 +66,21 @@ func (h *RouterGroup) Routes() {
 		group.POST("/updates/", middleware.Middleware(h.UpdateMetrics))
 		group.POST("/value/", middleware.Middleware(h.GetMetric))
 		group.GET("/value/:type/:name", middleware.Middleware(h.GetMetricByPath))
+		group.GET("/query_range", middleware.Middleware(h.QueryRange))
+		group.POST("/query_range/", middleware.Middleware(h.QueryRangeByBody))
 		group.GET("/ping", middleware.Middleware(h.Ping))
+		group.GET("/alerts", middleware.Middleware(h.GetAlerts))
+		group.GET("/metrics", middleware.Middleware(h.PrometheusMetrics))
+		group.POST("/api/v1/write", middleware.Middleware(h.RemoteWrite))
+		group.GET("/agents", middleware.Middleware(h.GetAgents))
+		group.GET("/internal/metrics", middleware.Middleware(h.InternalMetrics))
 	}
 }
 
-// Ping - GET request for checking db working.
+// Ping - GET request for checking storage working.
 func (h *RouterGroup) Ping(c *gin.Context) ([]byte, error) {
 	log.Println("Ping")
-	if h.db.DB == nil {
-		err := errors.New("can't connect to db")
-		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
-		return nil, err
-	}
-	if err := h.db.DBPing(c); err != nil {
+	if err := h.repo.Ping(c); err != nil {
 		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
 		return nil, err
 	}
@@ -87,26 +107,15 @@ func (h *RouterGroup) GetMetric(c *gin.Context) ([]byte, error) {
 	if requestBody.ID == "" {
 		return nil, middleware.ErrNotFound
 	}
-	if h.useDB {
-		responseBody, err = h.db.GetMetric(c, requestBody.ID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return nil, err
-		}
-	} else {
-		response, ok := h.s.Metrics[requestBody.ID]
-		if !ok {
-			return nil, middleware.ErrNotFound
-		}
-		response.MType = strings.ToLower(response.MType)
-		responseBody = response
+	responseBody, err = h.repo.GetMetricByType(c, requestBody.MType, requestBody.ID, requestBody.Labels)
+	if err != nil {
+		log.Println(err)
+		return nil, middleware.ErrNotFound
 	}
+	responseBody.MType = strings.ToLower(responseBody.MType)
+
 	if h.key != "" {
-		if strings.ToLower(requestBody.MType) == "gauge" {
-			hashValue, err = hashCreate(fmt.Sprintf("%s:gauge:%f", responseBody.ID, *responseBody.Value), []byte(h.key))
-		} else {
-			hashValue, err = hashCreate(fmt.Sprintf("%s:counter:%d", responseBody.ID, *responseBody.Delta), []byte(h.key))
-		}
+		hashValue, err = hashCreate(responseBody.HashData(), []byte(h.key))
 		if err != nil {
 			log.Println(err)
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -115,11 +124,6 @@ func (h *RouterGroup) GetMetric(c *gin.Context) ([]byte, error) {
 		responseBody.Hash = hashValue
 	}
 
-	if !strings.EqualFold(responseBody.MType, requestBody.MType) {
-		http.Error(w, "type is not correct", http.StatusNotFound)
-		return nil, err
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	body, err := json.Marshal(responseBody)
 	if err != nil {
@@ -131,96 +135,197 @@ func (h *RouterGroup) GetMetric(c *gin.Context) ([]byte, error) {
 
 }
 
-// GetMetricByPath - GET request for get metric by url value
+// GetMetricByPath - GET request for get metric by url value.
+// Query parameter match selects series by labels, for example match={host="h1"}.
+// Without match the series without labels is returned, if it doesn't exist - the first series of the metric.
 func (h *RouterGroup) GetMetricByPath(c *gin.Context) ([]byte, error) {
 	r := c.Request
 	w := c.Writer
-	var err error
 	log.Println("Get Metrics", r.URL)
-	log.Println("Metrics Body: ", r.Body)
-	mType := c.Params.ByName("type")
+	mType := strings.ToLower(c.Params.ByName("type"))
 	name := c.Params.ByName("name")
-	if mType == "" || name == "" {
+	if name == "" || (mType != "gauge" && mType != "counter") {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, middleware.ErrNotFound
+	}
+	matchers, err := client.ParseMatchers(c.Query("match"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", middleware.ErrBadRequest, err)
+	}
+
+	metric, err := h.findSeries(c, mType, name, matchers)
+	if err != nil {
+		log.Println(err)
 		w.WriteHeader(http.StatusNotFound)
 		return nil, middleware.ErrNotFound
 	}
 
 	var response []byte
-	if strings.ToLower(mType) == "gauge" {
-		var result *float64
-		if h.useDB {
-			result, err = h.db.GetGaugeMetric(c, name)
-			if err != nil {
-				log.Println(err)
-				w.WriteHeader(http.StatusNotFound)
-				return nil, middleware.ErrNotFound
-			}
-		} else {
-			metric, ok := h.s.Metrics[name]
-			if !ok {
-				w.WriteHeader(http.StatusNotFound)
-				return nil, middleware.ErrNotFound
-			}
-			if metric.Value == nil {
-				w.WriteHeader(http.StatusNotFound)
-				return nil, middleware.ErrNotFound
-			}
-			result = metric.Value
+	if mType == "gauge" {
+		response = []byte(fmt.Sprintf("%v", *metric.Value))
+	} else {
+		response = []byte(fmt.Sprintf("%v", *metric.Delta))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	return response, nil
+}
+
+// findSeries - first series (sorted by key) of metric with the type which labels match all matchers.
+// Series without labels goes first.
+func (h *RouterGroup) findSeries(ctx context.Context, mType, name string, matchers []client.Matcher) (client.Metrics, error) {
+	if len(matchers) == 0 {
+		// серия без меток - самый частый случай, получаем её одним запросом
+		if m, err := h.repo.GetMetricByType(ctx, mType, name, nil); err == nil {
+			return m, nil
 		}
+	}
+	series, err := h.repo.GetSeries(ctx, name)
+	if err != nil {
+		return client.Metrics{}, err
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Key() < series[j].Key() })
 
-		response = []byte(fmt.Sprintf("%v", *result))
-		w.WriteHeader(http.StatusOK)
-	} else if strings.ToLower(mType) == "counter" {
-		var result *int64
-		if h.useDB {
-			result, err = h.db.GetCounterMetric(c, name)
-			if err != nil {
-				log.Println(err)
-				w.WriteHeader(http.StatusNotFound)
-				return nil, middleware.ErrNotFound
-			}
-		} else {
-			metric, ok := h.s.Metrics[name]
-			if !ok {
-				w.WriteHeader(http.StatusNotFound)
-				return nil, middleware.ErrNotFound
-			}
-			if metric.Delta == nil {
-				w.WriteHeader(http.StatusNotFound)
-				return nil, middleware.ErrNotFound
-			}
-			result = metric.Delta
+	for _, m := range series {
+		if !strings.EqualFold(m.MType, mType) || !client.MatchLabels(m.Labels, matchers) {
+			continue
+		}
+		if (mType == "gauge" && m.Value != nil) || (mType == "counter" && m.Delta != nil) {
+			return m, nil
 		}
+	}
+	return client.Metrics{}, middleware.ErrNotFound
+}
 
-		response = []byte(fmt.Sprintf("%v", *result))
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusNotFound)
+// QueryRange - GET request for get metric values between start and end.
+// Params: type, name, start, end (RFC3339 or unix seconds), step (duration or seconds), agg (min, max, avg, last, rate).
+func (h *RouterGroup) QueryRange(c *gin.Context) ([]byte, error) {
+	q, err := NewRangeQuery(c.Query("name"), c.Query("type"), c.Query("start"), c.Query("end"), c.Query("step"), c.Query("agg"), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	matchers, err := client.ParseMatchers(c.Query("match"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", middleware.ErrBadRequest, err)
+	}
+	series, err := h.findSeries(c, q.MType, q.ID, matchers)
+	if err != nil {
 		return nil, middleware.ErrNotFound
 	}
+	q.Labels = series.Labels
+	return h.queryRange(c, q)
+}
 
-	return response, nil
+// QueryRangeByBody - POST request for get metric values between start and end by body value
+func (h *RouterGroup) QueryRangeByBody(c *gin.Context) ([]byte, error) {
+	var requestBody rangeQueryBody
+	if err := json.NewDecoder(c.Request.Body).Decode(&requestBody); err != nil {
+		return nil, fmt.Errorf("%w: %v", middleware.ErrBadRequest, err)
+	}
+	var params [3]string
+	for i, raw := range []json.RawMessage{requestBody.Start, requestBody.End, requestBody.Step} {
+		value, err := rawString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", middleware.ErrBadRequest, err)
+		}
+		params[i] = value
+	}
+	q, err := NewRangeQuery(requestBody.ID, requestBody.MType, params[0], params[1], params[2], requestBody.Agg, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	q.Labels = requestBody.Labels
+	return h.queryRange(c, q)
+}
+
+func (h *RouterGroup) queryRange(c *gin.Context, q RangeQuery) ([]byte, error) {
+	// берём одно значение до начала интервала для вычисления rate
+	from := q.Start.Add(-q.Step)
+	samples, err := h.repo.GetHistory(c, q.ID, q.Labels, from, q.End)
+	if errors.Is(err, ErrHistoryDisabled) {
+		return nil, fmt.Errorf("%w: %v", middleware.ErrBadRequest, err)
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	filtered := samples[:0]
+	for _, sample := range samples {
+		if strings.EqualFold(sample.MType, q.MType) {
+			filtered = append(filtered, sample)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, middleware.ErrNotFound
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	body, err := json.Marshal(RangeResponse{
+		ID:     q.ID,
+		MType:  q.MType,
+		Labels: q.Labels,
+		Agg:    q.Agg,
+		Step:   q.Step.String(),
+		Points: q.Aggregate(filtered),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
 }
 
-// MetricList - GET request for get all metrics
+// MetricList - GET request for get all metrics.
+// Query parameter match filters series by labels, for example match={host="h1"}.
 func (h *RouterGroup) MetricList(c *gin.Context) ([]byte, error) {
+	matchers, err := client.ParseMatchers(c.Query("match"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", middleware.ErrBadRequest, err)
+	}
 	c.Writer.Header().Set("Content-Type", "text/html")
+	metrics, err := h.repo.GetMetrics(c)
+	if err != nil {
+		return nil, err
+	}
 	var values []string
-	var err error
-	if h.useDB {
-		values, err = h.db.GetMetricNames(c)
-		if err != nil {
-			return nil, err
+	for _, m := range metrics {
+		if client.MatchLabels(m.Labels, matchers) {
+			values = append(values, m.Key())
 		}
-	} else {
-		for _, m := range h.s.Metrics {
-			values = append(values, m.ID)
-		}
-
 	}
+	sort.Strings(values)
 	return []byte(createResponse(values)), nil
 }
 
+// PrometheusMetrics - GET request for get all metrics in Prometheus text exposition format
+func (h *RouterGroup) PrometheusMetrics(c *gin.Context) ([]byte, error) {
+	metrics, err := h.repo.GetMetrics(c)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = prometheus.WriteText(&buf, metrics); err != nil {
+		return nil, err
+	}
+	c.Writer.Header().Set("Content-Type", prometheus.ContentType)
+	return buf.Bytes(), nil
+}
+
+// InternalMetrics - GET request for statistics of the storage in Prometheus text exposition format.
+// Empty for storages without statistics.
+func (h *RouterGroup) InternalMetrics(c *gin.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	if stats, ok := h.repo.(StatsWriter); ok {
+		if err := stats.WriteStats(&buf); err != nil {
+			return nil, err
+		}
+	}
+	c.Writer.Header().Set("Content-Type", prometheus.ContentType)
+	return buf.Bytes(), nil
+}
+
 // UpdateMetricByPath - GET request for update metric by url value
 func (h *RouterGroup) UpdateMetricByPath(c *gin.Context) ([]byte, error) {
 	r := c.Request
@@ -233,6 +338,7 @@ func (h *RouterGroup) UpdateMetricByPath(c *gin.Context) ([]byte, error) {
 		w.WriteHeader(http.StatusNotFound)
 		return nil, middleware.ErrNotFound
 	}
+	labels := h.agentLabels(c, nil)
 
 	if strings.ToLower(mType) == "gauge" {
 		v, err := strconv.ParseFloat(mValue, 64)
@@ -240,22 +346,15 @@ func (h *RouterGroup) UpdateMetricByPath(c *gin.Context) ([]byte, error) {
 			w.WriteHeader(http.StatusBadRequest)
 			return nil, middleware.NewAppError(nil, fmt.Sprintf("Value should be type float64: value%s", mType))
 		}
-		if h.useDB {
-			err = h.db.UpdateMetric(c, client.Metrics{
-				ID:    name,
-				MType: mType,
-				Delta: nil,
-				Value: &v,
-			})
-		} else {
-			h.s.SaveGaugeMetric(&client.Metrics{
-				ID:    name,
-				MType: mType,
-				Value: &v,
-			})
-		}
+		err = h.repo.UpdateMetric(c, client.Metrics{
+			ID:     name,
+			MType:  mType,
+			Value:  &v,
+			Labels: labels,
+		})
 		if err != nil {
 			log.Println(err)
+			return nil, saveError(err)
 		}
 	} else if strings.ToLower(mType) == "counter" {
 		v, err := strconv.ParseInt(mValue, 10, 64)
@@ -264,22 +363,15 @@ func (h *RouterGroup) UpdateMetricByPath(c *gin.Context) ([]byte, error) {
 			return nil, middleware.NewAppError(nil, fmt.Sprintf("Value should be type int64: value%s", mValue))
 		}
 
-		if h.useDB {
-			err = h.db.UpdateMetric(c, client.Metrics{
-				ID:    name,
-				MType: mType,
-				Delta: &v,
-				Value: nil,
-			})
-		} else {
-			h.s.SaveCountMetric(client.Metrics{
-				ID:    name,
-				MType: mType,
-				Delta: &v,
-			})
-		}
+		err = h.repo.UpdateMetric(c, client.Metrics{
+			ID:     name,
+			MType:  mType,
+			Delta:  &v,
+			Labels: labels,
+		})
 		if err != nil {
 			log.Println(err)
+			return nil, saveError(err)
 		}
 	} else {
 		return nil, middleware.UnknownMetricName
@@ -306,6 +398,8 @@ func (h *RouterGroup) UpdateMetric(c *gin.Context) ([]byte, error) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return nil, err
 	}
+	// хеш проверяется по телу запроса, метка агента добавляется после проверки
+	labels := h.agentLabels(c, requestBody.Labels)
 
 	if strings.ToLower(requestBody.MType) == "gauge" {
 		if requestBody.Value == nil {
@@ -313,7 +407,7 @@ func (h *RouterGroup) UpdateMetric(c *gin.Context) ([]byte, error) {
 			return nil, middleware.ErrNotFound
 		}
 		if h.key != "" && requestBody.Hash != "" {
-			ok, err := hash(requestBody.Hash, fmt.Sprintf("%s:gauge:%f", requestBody.ID, *requestBody.Value), []byte(h.key))
+			ok, err := hash(requestBody.Hash, requestBody.HashData(), []byte(h.key))
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return nil, err
@@ -323,22 +417,15 @@ func (h *RouterGroup) UpdateMetric(c *gin.Context) ([]byte, error) {
 				return nil, err
 			}
 		}
-		if h.useDB {
-			err := h.db.UpdateMetric(c, client.Metrics{
-				ID:    requestBody.ID,
-				MType: strings.ToLower(requestBody.MType),
-				Delta: nil,
-				Value: requestBody.Value,
-			})
-			if err != nil {
-				log.Println(err)
-			}
-		} else {
-			h.s.SaveGaugeMetric(&client.Metrics{
-				ID:    requestBody.ID,
-				MType: strings.ToLower(requestBody.MType),
-				Value: requestBody.Value,
-			})
+		err := h.repo.UpdateMetric(c, client.Metrics{
+			ID:     requestBody.ID,
+			MType:  strings.ToLower(requestBody.MType),
+			Value:  requestBody.Value,
+			Labels: labels,
+		})
+		if err != nil {
+			log.Println(err)
+			return nil, saveError(err)
 		}
 
 	} else if strings.ToLower(requestBody.MType) == "counter" {
@@ -347,7 +434,7 @@ func (h *RouterGroup) UpdateMetric(c *gin.Context) ([]byte, error) {
 			return nil, middleware.ErrNotFound
 		}
 		if h.key != "" && requestBody.Hash != "" {
-			ok, err := hash(requestBody.Hash, fmt.Sprintf("%s:counter:%d", requestBody.ID, *requestBody.Delta), []byte(h.key))
+			ok, err := hash(requestBody.Hash, requestBody.HashData(), []byte(h.key))
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return nil, err
@@ -358,22 +445,15 @@ func (h *RouterGroup) UpdateMetric(c *gin.Context) ([]byte, error) {
 			}
 		}
 
-		if h.useDB {
-			err := h.db.UpdateMetric(c, client.Metrics{
-				ID:    requestBody.ID,
-				MType: strings.ToLower(requestBody.MType),
-				Delta: requestBody.Delta,
-				Value: nil,
-			})
-			if err != nil {
-				log.Println(err)
-			}
-		} else {
-			h.s.SaveCountMetric(client.Metrics{
-				ID:    requestBody.ID,
-				MType: strings.ToLower(requestBody.MType),
-				Delta: requestBody.Delta,
-			})
+		err := h.repo.UpdateMetric(c, client.Metrics{
+			ID:     requestBody.ID,
+			MType:  strings.ToLower(requestBody.MType),
+			Delta:  requestBody.Delta,
+			Labels: labels,
+		})
+		if err != nil {
+			log.Println(err)
+			return nil, saveError(err)
 		}
 	} else {
 		w.WriteHeader(http.StatusNotImplemented)
@@ -384,31 +464,212 @@ func (h *RouterGroup) UpdateMetric(c *gin.Context) ([]byte, error) {
 	return nil, nil
 }
 
-// UpdateMetrics - POST request for update all metrics in body by body value
+// UpdateResult - result of update of one item of batch
+type UpdateResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id"`
+	MType string `json:"type"`
+	Error string `json:"error,omitempty"`
+}
+
+// UpdatesResponse - response of batch update with accepted and rejected items
+type UpdatesResponse struct {
+	Accepted []UpdateResult `json:"accepted"`
+	Rejected []UpdateResult `json:"rejected"`
+}
+
+// UpdateMetrics - POST request for update all metrics in body by body value.
+// Every item is validated, valid items are saved together, the response reports accepted and rejected items.
+// If all items are rejected, status is 400.
 func (h *RouterGroup) UpdateMetrics(c *gin.Context) ([]byte, error) {
 	r := c.Request
-	w := c.Writer
-	log.Println("UpdateMetric Metrics", r.URL)
-	log.Println("Metrics Body: ", r.Body)
+	log.Println("UpdateMetrics Metrics", r.URL)
 
 	var requestBody []client.Metrics
-
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, fmt.Errorf("%w: %v", middleware.ErrBadRequest, err)
+	}
+
+	response := UpdatesResponse{
+		Accepted: make([]UpdateResult, 0, len(requestBody)),
+		Rejected: make([]UpdateResult, 0),
+	}
+	metrics := make([]client.Metrics, 0, len(requestBody))
+	for i, m := range requestBody {
+		result := UpdateResult{Index: i, ID: m.ID, MType: m.MType}
+		if err := h.validateMetric(m); err != nil {
+			result.Error = err.Error()
+			response.Rejected = append(response.Rejected, result)
+			continue
+		}
+		// хеш проверяется по телу запроса, метка агента добавляется после проверки
+		m.MType = strings.ToLower(m.MType)
+		m.Labels = h.agentLabels(c, m.Labels)
+		m.Hash = ""
+		metrics = append(metrics, m)
+		response.Accepted = append(response.Accepted, result)
+	}
+
+	if err := h.saveMetrics(c, metrics); err != nil {
+		log.Println(err)
+		return nil, saveError(err)
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
 		return nil, err
 	}
-	if h.useDB {
-		err := h.db.UpdateMetrics(requestBody)
+	c.Writer.Header().Set("Content-Type", "application/json")
+	if len(response.Accepted) == 0 && len(response.Rejected) > 0 {
+		c.Writer.WriteHeader(http.StatusBadRequest)
+	}
+	return body, nil
+}
+
+// validateMetric - check type, value and hash of metric from batch
+func (h *RouterGroup) validateMetric(m client.Metrics) error {
+	if m.ID == "" {
+		return errors.New("empty metric id")
+	}
+	switch strings.ToLower(m.MType) {
+	case "gauge":
+		if m.Value == nil {
+			return errors.New("gauge value is empty")
+		}
+	case "counter":
+		if m.Delta == nil {
+			return errors.New("counter delta is empty")
+		}
+	default:
+		return fmt.Errorf("unknown metric type %q", m.MType)
+	}
+	if h.key != "" && m.Hash != "" {
+		ok, err := hash(m.Hash, m.HashData(), []byte(h.key))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+		if !ok {
+			return errors.New("hash mismatch")
 		}
 	}
+	return nil
+}
 
-	w.WriteHeader(http.StatusOK)
+// GetAlerts - GET request for get alert rules and alerts state.
+// Query parameter state filters alerts by state (pending, firing, resolved).
+func (h *RouterGroup) GetAlerts(c *gin.Context) ([]byte, error) {
+	response := struct {
+		Rules  []alerting.Rule  `json:"rules"`
+		Alerts []alerting.Alert `json:"alerts"`
+	}{
+		Rules:  []alerting.Rule{},
+		Alerts: []alerting.Alert{},
+	}
+	if h.alerts != nil {
+		response.Rules = h.alerts.Rules()
+		state := c.Query("state")
+		for _, alert := range h.alerts.Alerts() {
+			if state != "" && !strings.EqualFold(string(alert.State), state) {
+				continue
+			}
+			response.Alerts = append(response.Alerts, alert)
+		}
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// RemoteWrite - POST request with Prometheus remote write payload (snappy compressed protobuf).
+// The latest sample of every series is saved as gauge or counter.
+func (h *RouterGroup) RemoteWrite(c *gin.Context) ([]byte, error) {
+	req, err := prometheus.DecodeWriteRequest(c.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", middleware.ErrBadRequest, err)
+	}
+	metrics, pending := h.remoteWrite.Convert(c, req)
+	log.Printf("RemoteWrite: %d series, %d metrics", len(req.Timeseries), len(metrics))
 
+	if err = h.saveMetrics(c, metrics); err != nil {
+		log.Println(err)
+		return nil, saveError(err)
+	}
+	// Prometheus повторяет запрос после ошибки: прирост учитывается только после сохранения
+	h.remoteWrite.Commit(pending)
+	c.Writer.WriteHeader(http.StatusNoContent)
 	return nil, nil
 }
 
+// SetAgents - set agents registry
+func (h *RouterGroup) SetAgents(agents *Agents) {
+	h.agents = agents
+}
+
+// WatchAgents - save synthetic up gauge of every agent until ctx is done.
+// Agents of saved up series are restored first, so agent which never comes back becomes stale.
+func (h *RouterGroup) WatchAgents(ctx context.Context) {
+	if series, err := h.repo.GetSeries(ctx, UpMetric); err != nil {
+		log.Println("Can't restore agents: ", err)
+	} else {
+		h.agents.Restore(series, time.Now())
+	}
+	h.agents.Run(ctx, h.saveMetrics)
+}
+
+// GetAgents - GET request for get agents which sent metrics, their last-seen time and state.
+// Query parameter state filters agents by state (up, stale).
+func (h *RouterGroup) GetAgents(c *gin.Context) ([]byte, error) {
+	agents := make([]Agent, 0)
+	state := c.Query("state")
+	for _, agent := range h.agents.List(time.Now()) {
+		if state == "" || strings.EqualFold(string(agent.State), state) {
+			agents = append(agents, agent)
+		}
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	body, err := json.Marshal(agents)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// agentLabels - add instance label from agent header if metric doesn't have it
+// and mark the agent as seen.
+func (h *RouterGroup) agentLabels(c *gin.Context, labels client.Labels) client.Labels {
+	if id := c.GetHeader(client.AgentHeader); id != "" && labels[client.InstanceLabel] == "" {
+		labels = labels.Copy()
+		if labels == nil {
+			labels = make(client.Labels, 1)
+		}
+		labels[client.InstanceLabel] = id
+	}
+	if instance := labels[client.InstanceLabel]; instance != "" {
+		h.agents.Seen(instance, c.ClientIP(), time.Now())
+	}
+	return labels
+}
+
+// saveError - error of storage update for response: type conflict is shown to the client, others are internal
+func saveError(err error) error {
+	if errors.Is(err, middleware.ErrConflict) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", middleware.ErrInternal, err)
+}
+
+// saveMetrics - save batch of metrics in storage
+func (h *RouterGroup) saveMetrics(ctx context.Context, metrics []client.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	return h.repo.UpdateMetrics(ctx, metrics)
+}
+
 func hashCreate(m string, key []byte) (string, error) {
 	h := hmac.New(sha256.New, key)
 	_, err := h.Write([]byte(m))
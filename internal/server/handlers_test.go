@@ -1,18 +1,32 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/snappy"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 
 	"github.com/iddanilov/metricsAndAlerting/internal/db"
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
 	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+	"github.com/iddanilov/metricsAndAlerting/internal/server/mocks"
 )
 
 var (
@@ -131,14 +145,10 @@ func TestSendGauge(t *testing.T) {
 			storage := Storage{
 				Metrics: make(map[string]client.Metrics, 10),
 			}
-			db, err := db.NewDB("host=localhost user=admin password=password dbname=postgres port=6432 sslmode=disable")
-			if err != nil {
-				panic(err)
-			}
 
 			r := gin.New()
 			r.RedirectTrailingSlash = false
-			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL", db, false)
+			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL")
 			rg.Routes()
 
 			// запускаем сервер
@@ -273,13 +283,9 @@ func TestSendCounter(t *testing.T) {
 			storage := Storage{
 				Metrics: make(map[string]client.Metrics, 10),
 			}
-			db, err := db.NewDB("host=localhost user=admin password=password dbname=postgres port=6432 sslmode=disable")
-			if err != nil {
-				panic(err)
-			}
 			r := gin.New()
 			r.RedirectTrailingSlash = false
-			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL", db, false)
+			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL")
 			rg.Routes()
 
 			// запускаем сервер
@@ -365,11 +371,7 @@ func TestGetMetric(t *testing.T) {
 			}
 			r := gin.New()
 			r.RedirectTrailingSlash = false
-			db, err := db.NewDB("host=localhost user=admin password=password dbname=postgres port=6432 sslmode=disable")
-			if err != nil {
-				panic(err)
-			}
-			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL", db, false)
+			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL")
 			rg.Routes()
 
 			// запускаем сервер
@@ -450,14 +452,10 @@ func TestGetGauge(t *testing.T) {
 			if !tt.counterMetricResult.MetricISEmpty() {
 				storage.Metrics[tt.counterMetricResult.ID] = client.Metrics{ID: tt.counterMetricResult.ID, MType: tt.counterMetricResult.MType, Value: tt.counterMetricResult.Value, Delta: tt.counterMetricResult.Delta}
 			}
-			db, err := db.NewDB("host=localhost user=admin password=password dbname=postgres port=6432 sslmode=disable")
-			if err != nil {
-				panic(err)
-			}
 
 			r := gin.New()
 			r.RedirectTrailingSlash = false
-			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL", db, false)
+			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL")
 			rg.Routes()
 
 			// запускаем сервер
@@ -531,13 +529,9 @@ func TestGetMetrics(t *testing.T) {
 			storage := Storage{
 				Metrics: make(map[string]client.Metrics, 10),
 			}
-			db, err := db.NewDB("host=localhost user=admin password=password dbname=postgres port=6432 sslmode=disable")
-			if err != nil {
-				panic(err)
-			}
 			r := gin.New()
 			r.RedirectTrailingSlash = false
-			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL", db, false)
+			rg := NewRouterGroup(&r.RouterGroup, &storage, "LOOOOOOOOOOOOOOL")
 			rg.Routes()
 
 			// запускаем сервер
@@ -611,3 +605,434 @@ func TestGetCreateResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestPrometheusMetrics(t *testing.T) {
+	storage := Storage{
+		Metrics: map[string]client.Metrics{
+			"Alloc":     {ID: "Alloc", MType: "Gauge", Value: &baseFloat},
+			"PollCount": {ID: "PollCount", MType: "counter", Delta: &baseInt},
+		},
+	}
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	r := gin.New()
+	rg := NewRouterGroup(&r.RouterGroup, &storage, "")
+	rg.Routes()
+
+	r.ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "# TYPE Alloc gauge\nAlloc 5.5\n")
+	assert.Contains(t, string(body), "# TYPE PollCount counter\nPollCount 5\n")
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var label, sample, series, body []byte
+	label = protowire.AppendTag(label, 1, protowire.BytesType)
+	label = protowire.AppendString(label, "__name__")
+	label = protowire.AppendTag(label, 2, protowire.BytesType)
+	label = protowire.AppendString(label, "node_load1")
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(baseFloat))
+	series = protowire.AppendTag(series, 1, protowire.BytesType)
+	series = protowire.AppendBytes(series, label)
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+	body = protowire.AppendTag(body, 1, protowire.BytesType)
+	body = protowire.AppendBytes(body, series)
+
+	tests := []struct {
+		name string
+		body []byte
+		code int
+	}{
+		{
+			name: "[Positive] Запрос remote write - получаю 204; данные сохранены",
+			body: snappy.Encode(nil, body),
+			code: http.StatusNoContent,
+		},
+		{
+			name: "[Negative] Запрос remote write без сжатия - получаю 400",
+			body: body,
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := Storage{
+				Metrics: make(map[string]client.Metrics, 10),
+			}
+			request := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tt.body))
+			request.Header.Set("Content-Encoding", "snappy")
+			request.Header.Set("Content-Type", "application/x-protobuf")
+			w := httptest.NewRecorder()
+			r := gin.New()
+			rg := NewRouterGroup(&r.RouterGroup, &storage, "")
+			rg.Routes()
+
+			r.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.code, res.StatusCode)
+			if tt.code == http.StatusNoContent {
+				assert.Equal(t, baseFloat, *storage.Metrics["node_load1"].Value)
+			} else {
+				assert.Empty(t, storage.Metrics)
+			}
+		})
+	}
+}
+
+func TestMetricsWithLabels(t *testing.T) {
+	storage := Storage{
+		Metrics: make(map[string]client.Metrics, 10),
+	}
+	r := gin.New()
+	r.RedirectTrailingSlash = false
+	rg := NewRouterGroup(&r.RouterGroup, &storage, "")
+	rg.Routes()
+
+	for _, body := range []string{
+		`{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"h1"}}`,
+		`{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"h2"}}`,
+		`{"id":"PollCount","type":"counter","delta":3,"labels":{"host":"h1"}}`,
+		`{"id":"PollCount","type":"counter","delta":4,"labels":{"host":"h2"}}`,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Len(t, storage.Metrics, 4)
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		code     int
+		response string
+	}{
+		{
+			name:     "[Positive] Получение серии по метке - получаю 200",
+			method:   http.MethodGet,
+			url:      `/value/gauge/Alloc?match={host="h2"}`,
+			code:     http.StatusOK,
+			response: "2",
+		},
+		{
+			name:     "[Positive] Получение серии без фильтра - получаю первую серию",
+			method:   http.MethodGet,
+			url:      `/value/counter/PollCount`,
+			code:     http.StatusOK,
+			response: "3",
+		},
+		{
+			name:   "[Negative] Серия с меткой не найдена - получаю 404",
+			method: http.MethodGet,
+			url:    `/value/gauge/Alloc?match={host="h3"}`,
+			code:   http.StatusNotFound,
+		},
+		{
+			name:   "[Negative] Некорректный фильтр - получаю 400",
+			method: http.MethodGet,
+			url:    `/value/gauge/Alloc?match={host=h3}`,
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:     "[Positive] Получение серии по телу запроса с метками",
+			method:   http.MethodPost,
+			url:      "/value/",
+			body:     `{"id":"PollCount","type":"counter","labels":{"host":"h2"}}`,
+			code:     http.StatusOK,
+			response: `{"id":"PollCount","type":"counter","delta":4,"labels":{"host":"h2"}}`,
+		},
+		{
+			name:     "[Positive] Список серий с фильтром по метке",
+			method:   http.MethodGet,
+			url:      `/?match={host="h1"}`,
+			code:     http.StatusOK,
+			response: `<h1><ul><li>Alloc{host="h1"}</li><li>PollCount{host="h1"}</li></ul></h1>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.code, res.StatusCode)
+			if tt.response != "" {
+				body, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.response, string(body))
+			}
+		})
+	}
+}
+
+func TestAgentSeries(t *testing.T) {
+	storage := Storage{
+		Metrics: make(map[string]client.Metrics, 10),
+	}
+	r := gin.New()
+	r.RedirectTrailingSlash = false
+	rg := NewRouterGroup(&r.RouterGroup, &storage, "")
+	rg.Routes()
+
+	for _, agent := range []string{"host1", "host2", "host1"} {
+		request := httptest.NewRequest(http.MethodPost, "/update/counter/PollCount/5", nil)
+		request.Header.Set(client.AgentHeader, agent)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(`{"id":"Alloc","type":"gauge","value":1,"labels":{"instance":"host3"}}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, int64(10), *storage.Metrics[`PollCount{instance="host1"}`].Delta)
+	assert.Equal(t, int64(5), *storage.Metrics[`PollCount{instance="host2"}`].Delta)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/agents", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var agents []Agent
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &agents))
+	if assert.Len(t, agents, 3) {
+		assert.Equal(t, "host1", agents[0].ID)
+		assert.Equal(t, "host3", agents[2].ID)
+		assert.False(t, agents[0].LastSeen.IsZero())
+	}
+}
+
+func TestUpdateMetrics(t *testing.T) {
+	key := "secret"
+	validHash, err := hashCreate("Alloc:gauge:1.500000", []byte(key))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		body     string
+		code     int
+		accepted int
+		rejected int
+	}{
+		{
+			name:     "[Positive] Batch из gauge и counter - получаю 200; counter суммируется, gauge заменяется",
+			body:     `[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter","delta":2},{"id":"PollCount","type":"counter","delta":3},{"id":"Alloc","type":"gauge","value":1.5,"hash":"` + validHash + `"}]`,
+			code:     http.StatusOK,
+			accepted: 4,
+		},
+		{
+			name:     "[Positive] Batch с некорректными элементами - получаю 200; сохранены только корректные",
+			body:     `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"","type":"gauge","value":1},{"id":"Alloc","type":"histogram","value":1},{"id":"PollCount","type":"counter"},{"id":"Alloc","type":"gauge","value":2,"hash":"bad"}]`,
+			code:     http.StatusOK,
+			accepted: 1,
+			rejected: 4,
+		},
+		{
+			name:     "[Negative] Все элементы batch некорректны - получаю 400; данные не сохранены",
+			body:     `[{"id":"Alloc","type":"gauge"}]`,
+			code:     http.StatusBadRequest,
+			rejected: 1,
+		},
+		{
+			name: "[Negative] Некорректный json - получаю 400",
+			body: `{"id":"Alloc"`,
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := Storage{
+				Metrics: make(map[string]client.Metrics, 10),
+			}
+			r := gin.New()
+			r.RedirectTrailingSlash = false
+			rg := NewRouterGroup(&r.RouterGroup, &storage, key)
+			rg.Routes()
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.code, w.Code)
+			if tt.accepted == 0 && tt.rejected == 0 {
+				return
+			}
+
+			var response UpdatesResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Len(t, response.Accepted, tt.accepted)
+			assert.Len(t, response.Rejected, tt.rejected)
+			for _, rejected := range response.Rejected {
+				assert.NotEmpty(t, rejected.Error)
+			}
+			if tt.accepted == 0 {
+				assert.Empty(t, storage.Metrics)
+				return
+			}
+			assert.Equal(t, 1.5, *storage.Metrics["Alloc"].Value)
+		})
+	}
+
+	storage := Storage{
+		Metrics: make(map[string]client.Metrics, 10),
+	}
+	r := gin.New()
+	rg := NewRouterGroup(&r.RouterGroup, &storage, "")
+	rg.Routes()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tests[0].body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(5), *storage.Metrics["PollCount"].Delta)
+}
+
+func TestRepository(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	repo := mocks.NewMockRepository(ctrl)
+
+	r := gin.New()
+	r.RedirectTrailingSlash = false
+	rg := NewRouterGroup(&r.RouterGroup, repo, "")
+	rg.Routes()
+
+	t.Run("[Negative] Хранилище недоступно - ping получает 500", func(t *testing.T) {
+		repo.EXPECT().Ping(gomock.Any()).Return(errors.New("connection refused"))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("[Positive] Значение метрики получено из хранилища", func(t *testing.T) {
+		repo.EXPECT().GetMetricByType(gomock.Any(), "gauge", "Alloc", client.Labels(nil)).
+			Return(client.Metrics{ID: "Alloc", MType: "gauge", Value: &baseFloat}, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/value/gauge/Alloc", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "5.5", w.Body.String())
+	})
+
+	t.Run("[Positive] Batch сохраняется одним вызовом без отклонённых элементов", func(t *testing.T) {
+		repo.EXPECT().UpdateMetrics(gomock.Any(), []client.Metrics{{ID: "PollCount", MType: "counter", Delta: &baseInt}})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"PollCount","type":"Counter","delta":5},{"id":"Alloc","type":"gauge"}]`)))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("[Negative] Ошибка хранилища при обновлении - получаю 500", func(t *testing.T) {
+		repo.EXPECT().UpdateMetric(gomock.Any(), gomock.Any()).Return(errors.New("connection refused"))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/5.5", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("[Negative] Метрика с именем сохранена с другим типом - получаю 409", func(t *testing.T) {
+		repo.EXPECT().UpdateMetric(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("%w: metric PollCount is counter, not gauge", middleware.ErrConflict))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/gauge/PollCount/5.5", nil))
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "metric PollCount is counter, not gauge")
+	})
+
+	t.Run("[Positive] Хранилище без статистики - пустой ответ internal metrics", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Body.String())
+	})
+}
+
+// TestUpdateConflictWithBuffer - 409 for name saved with another type when UpdateMetric is buffered, needs TEST_DATABASE_DSN
+func TestUpdateConflictWithBuffer(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN isn't set")
+	}
+	ctx := context.Background()
+	storage, err := db.NewDB(dsn, db.Options{BufferSize: 10, FlushInterval: time.Hour})
+	require.NoError(t, err)
+	defer storage.Close()
+	require.NoError(t, storage.CreateTable(ctx))
+
+	r := gin.New()
+	r.RedirectTrailingSlash = false
+	rg := NewRouterGroup(&r.RouterGroup, storage, "")
+	rg.Routes()
+
+	name := fmt.Sprintf("conflict_%d", time.Now().UnixNano())
+	for _, tt := range []struct {
+		url  string
+		code int
+	}{
+		{url: "/update/counter/" + name + "/1", code: http.StatusOK},
+		{url: "/update/gauge/" + name + "/1.5", code: http.StatusConflict},
+		{url: "/update/counter/" + name + "/2", code: http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.url, nil))
+		assert.Equal(t, tt.code, w.Code, tt.url)
+	}
+	m, err := storage.GetMetricByType(ctx, "counter", name, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *m.Delta)
+}
+
+// remoteWriteBody - snappy compressed remote write request with one sample of series
+func remoteWriteBody(name string, value float64) []byte {
+	var label, sample, series, body []byte
+	label = protowire.AppendTag(label, 1, protowire.BytesType)
+	label = protowire.AppendString(label, "__name__")
+	label = protowire.AppendTag(label, 2, protowire.BytesType)
+	label = protowire.AppendString(label, name)
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	series = protowire.AppendTag(series, 1, protowire.BytesType)
+	series = protowire.AppendBytes(series, label)
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+	body = protowire.AppendTag(body, 1, protowire.BytesType)
+	body = protowire.AppendBytes(body, series)
+	return snappy.Encode(nil, body)
+}
+
+func TestRemoteWriteRetry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	repo := mocks.NewMockRepository(ctrl)
+	r := gin.New()
+	rg := NewRouterGroup(&r.RouterGroup, repo, "")
+	rg.Routes()
+
+	counter := func(delta int64) []client.Metrics {
+		return []client.Metrics{{ID: "http_requests_total", MType: "counter", Delta: &delta}}
+	}
+	send := func(value float64) int {
+		w := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(remoteWriteBody("http_requests_total", value)))
+		request.Header.Set("Content-Encoding", "snappy")
+		r.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	repo.EXPECT().GetMetricByType(gomock.Any(), "counter", "http_requests_total", client.Labels(nil)).
+		Return(client.Metrics{}, middleware.ErrNotFound)
+	gomock.InOrder(
+		repo.EXPECT().UpdateMetrics(gomock.Any(), counter(10)),
+		repo.EXPECT().UpdateMetrics(gomock.Any(), counter(15)).Return(errors.New("connection refused")),
+		// повтор тех же сэмплов после ошибки сохраняет тот же прирост
+		repo.EXPECT().UpdateMetrics(gomock.Any(), counter(15)),
+	)
+	assert.Equal(t, http.StatusNoContent, send(10))
+	assert.Equal(t, http.StatusInternalServerError, send(25))
+	assert.Equal(t, http.StatusNoContent, send(25))
+}
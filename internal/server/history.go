@@ -0,0 +1,115 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// History - in-memory history of metric updates.
+// Every metric has ring buffer with the last size samples,
+// samples older than retention are removed.
+type History struct {
+	mutex     sync.RWMutex
+	series    map[string]*ring
+	size      int
+	retention time.Duration
+}
+
+// NewHistory - create history with size samples per metric.
+// Zero retention means samples are limited by size only.
+func NewHistory(size int, retention time.Duration) *History {
+	return &History{
+		series:    make(map[string]*ring),
+		size:      size,
+		retention: retention,
+	}
+}
+
+// Record - save metric value with server timestamp.
+func (h *History) Record(m client.Metrics, timestamp time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	r, ok := h.series[m.Key()]
+	if !ok {
+		r = newRing(h.size)
+		h.series[m.Key()] = r
+	}
+	r.push(client.NewSample(m, timestamp))
+	if h.retention > 0 {
+		r.dropBefore(timestamp.Add(-h.retention))
+	}
+}
+
+// Range - samples of series with timestamp in [from, to] ordered by time.
+// Key is metric name with labels, see models.Metrics.Key.
+func (h *History) Range(key string, from, to time.Time) []client.Sample {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	r, ok := h.series[key]
+	if !ok {
+		return nil
+	}
+	var result []client.Sample
+	r.each(func(sample client.Sample) {
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			return
+		}
+		result = append(result, sample)
+	})
+	return result
+}
+
+// DropBefore - remove samples recorded before the time. Returns number of removed samples.
+func (h *History) DropBefore(before time.Time) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var removed int64
+	for key, r := range h.series {
+		count := r.count
+		r.dropBefore(before)
+		removed += int64(count - r.count)
+		if r.count == 0 {
+			delete(h.series, key)
+		}
+	}
+	return removed
+}
+
+type ring struct {
+	samples []client.Sample
+	start   int
+	count   int
+}
+
+func newRing(size int) *ring {
+	return &ring{samples: make([]client.Sample, size)}
+}
+
+func (r *ring) push(sample client.Sample) {
+	if len(r.samples) == 0 {
+		return
+	}
+	if r.count < len(r.samples) {
+		r.samples[(r.start+r.count)%len(r.samples)] = sample
+		r.count++
+		return
+	}
+	r.samples[r.start] = sample
+	r.start = (r.start + 1) % len(r.samples)
+}
+
+func (r *ring) dropBefore(t time.Time) {
+	for r.count > 0 && r.samples[r.start].Timestamp.Before(t) {
+		r.samples[r.start] = client.Sample{}
+		r.start = (r.start + 1) % len(r.samples)
+		r.count--
+	}
+}
+
+func (r *ring) each(f func(sample client.Sample)) {
+	for i := 0; i < r.count; i++ {
+		f(r.samples[(r.start+i)%len(r.samples)])
+	}
+}
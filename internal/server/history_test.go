@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestHistoryRing(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := NewHistory(3, 0)
+	for i := 0; i < 5; i++ {
+		value := float64(i)
+		history.Record(client.Metrics{ID: "Alloc", MType: "gauge", Value: &value}, start.Add(time.Duration(i)*time.Second))
+	}
+
+	samples := history.Range("Alloc", start, start.Add(time.Hour))
+	require.Len(t, samples, 3)
+	for i, sample := range samples {
+		assert.Equal(t, float64(i+2), *sample.Value)
+		assert.Equal(t, start.Add(time.Duration(i+2)*time.Second), sample.Timestamp)
+	}
+
+	samples = history.Range("Alloc", start.Add(3*time.Second), start.Add(3*time.Second))
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(3), *samples[0].Value)
+
+	assert.Empty(t, history.Range("Sys", start, start.Add(time.Hour)))
+}
+
+func TestHistoryRetention(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := NewHistory(100, time.Minute)
+	for i := 0; i < 3; i++ {
+		delta := int64(i)
+		history.Record(client.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}, start.Add(time.Duration(i)*40*time.Second))
+	}
+
+	samples := history.Range("PollCount", start, start.Add(time.Hour))
+	require.Len(t, samples, 2)
+	assert.Equal(t, int64(1), *samples[0].Delta)
+
+	// фоновая задача удаляет устаревшую историю через DeleteHistory
+	assert.Equal(t, int64(2), history.DropBefore(start.Add(10*time.Minute)))
+	assert.Empty(t, history.Range("PollCount", start, start.Add(time.Hour)))
+}
+
+func TestStorageRecordsHistory(t *testing.T) {
+	storage := Storage{
+		Metrics: make(map[string]client.Metrics, 10),
+		History: NewHistory(10, 0),
+	}
+	for i := 0; i < 3; i++ {
+		delta := int64(2)
+		storage.SaveCountMetric(client.Metrics{ID: "PollCount", MType: "counter", Delta: &delta})
+	}
+
+	samples := storage.History.Range("PollCount", time.Time{}, time.Now())
+	require.Len(t, samples, 3)
+	assert.Equal(t, int64(2), *samples[0].Delta)
+	assert.Equal(t, int64(6), *samples[2].Delta)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+const (
+	defaultQueryRange = time.Hour
+	defaultQueryStep  = time.Minute
+	maxQueryPoints    = 11000
+)
+
+// RangeQuery - request of metric values between start and end.
+type RangeQuery struct {
+	ID     string        `json:"id"`
+	MType  string        `json:"type"`
+	Labels client.Labels `json:"labels,omitempty"`
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Step   time.Duration `json:"step"`
+	Agg    string        `json:"agg"` // min, max, avg, last или rate
+}
+
+// rangeQueryBody - json body of POST /query_range/, time and step can be set as string or number.
+type rangeQueryBody struct {
+	ID     string          `json:"id"`
+	MType  string          `json:"type"`
+	Labels client.Labels   `json:"labels"`
+	Start  json.RawMessage `json:"start"`
+	End    json.RawMessage `json:"end"`
+	Step   json.RawMessage `json:"step"`
+	Agg    string          `json:"agg"`
+}
+
+// Point - aggregated value for one step.
+type Point struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// RangeResponse - response of range query.
+type RangeResponse struct {
+	ID     string        `json:"id"`
+	MType  string        `json:"type"`
+	Labels client.Labels `json:"labels,omitempty"`
+	Agg    string        `json:"agg"`
+	Step   string        `json:"step"`
+	Points []Point       `json:"points"`
+}
+
+// NewRangeQuery - create query from string params. Empty params are replaced with defaults.
+func NewRangeQuery(id, mType, start, end, step, agg string, now time.Time) (RangeQuery, error) {
+	q := RangeQuery{
+		ID:    id,
+		MType: strings.ToLower(mType),
+		Agg:   strings.ToLower(agg),
+		End:   now,
+		Step:  defaultQueryStep,
+	}
+	var err error
+	if end != "" {
+		if q.End, err = parseTime(end); err != nil {
+			return RangeQuery{}, fmt.Errorf("%w: invalid end: %v", middleware.ErrBadRequest, err)
+		}
+	}
+	q.Start = q.End.Add(-defaultQueryRange)
+	if start != "" {
+		if q.Start, err = parseTime(start); err != nil {
+			return RangeQuery{}, fmt.Errorf("%w: invalid start: %v", middleware.ErrBadRequest, err)
+		}
+	}
+	if step != "" {
+		if q.Step, err = parseStep(step); err != nil {
+			return RangeQuery{}, fmt.Errorf("%w: invalid step: %v", middleware.ErrBadRequest, err)
+		}
+	}
+	if q.Agg == "" {
+		q.Agg = "last"
+	}
+	return q, q.Validate()
+}
+
+// Validate - check query params.
+func (q RangeQuery) Validate() error {
+	if q.ID == "" {
+		return fmt.Errorf("%w: metric name is empty", middleware.ErrBadRequest)
+	}
+	if q.MType != "gauge" && q.MType != "counter" {
+		return fmt.Errorf("%w: unknown metric type %q", middleware.ErrBadRequest, q.MType)
+	}
+	if q.End.Before(q.Start) {
+		return fmt.Errorf("%w: end is before start", middleware.ErrBadRequest)
+	}
+	if q.Step <= 0 {
+		return fmt.Errorf("%w: step should be positive", middleware.ErrBadRequest)
+	}
+	if q.End.Sub(q.Start)/q.Step > maxQueryPoints {
+		return fmt.Errorf("%w: too many points, increase step", middleware.ErrBadRequest)
+	}
+	switch q.Agg {
+	case "min", "max", "avg", "last":
+	case "rate":
+		if q.MType != "counter" {
+			return fmt.Errorf("%w: rate can be used only with counter", middleware.ErrBadRequest)
+		}
+	default:
+		return fmt.Errorf("%w: unknown aggregation %q", middleware.ErrBadRequest, q.Agg)
+	}
+	return nil
+}
+
+// Aggregate - split samples by step and aggregate values of every step.
+// Step with timestamp t contains samples from [t, t+step). Steps without samples are skipped.
+// For rate the last sample before step is used as a start value.
+func (q RangeQuery) Aggregate(samples []client.Sample) []Point {
+	points := make([]Point, 0)
+	var prev *client.Sample
+	i := 0
+	for i < len(samples) && samples[i].Timestamp.Before(q.Start) {
+		prev = &samples[i]
+		i++
+	}
+	for ts := q.Start; !ts.After(q.End); ts = ts.Add(q.Step) {
+		bucketEnd := ts.Add(q.Step)
+		first := i
+		for i < len(samples) && samples[i].Timestamp.Before(bucketEnd) && !samples[i].Timestamp.After(q.End) {
+			i++
+		}
+		bucket := samples[first:i]
+		if len(bucket) == 0 {
+			continue
+		}
+		if value, ok := q.aggregateBucket(prev, bucket); ok {
+			points = append(points, Point{Timestamp: ts, Value: value})
+		}
+		prev = &samples[i-1]
+	}
+	return points
+}
+
+func (q RangeQuery) aggregateBucket(prev *client.Sample, bucket []client.Sample) (float64, bool) {
+	switch q.Agg {
+	case "min":
+		result := math.Inf(1)
+		for _, s := range bucket {
+			result = math.Min(result, sampleValue(s))
+		}
+		return result, true
+	case "max":
+		result := math.Inf(-1)
+		for _, s := range bucket {
+			result = math.Max(result, sampleValue(s))
+		}
+		return result, true
+	case "avg":
+		var sum float64
+		for _, s := range bucket {
+			sum += sampleValue(s)
+		}
+		return sum / float64(len(bucket)), true
+	case "rate":
+		start := bucket[0]
+		if prev != nil {
+			start = *prev
+		} else {
+			bucket = bucket[1:]
+		}
+		if len(bucket) == 0 {
+			return 0, false
+		}
+		var increase float64
+		last := sampleValue(start)
+		for _, s := range bucket {
+			value := sampleValue(s)
+			if value < last {
+				// счётчик был сброшен
+				increase += value
+			} else {
+				increase += value - last
+			}
+			last = value
+		}
+		seconds := bucket[len(bucket)-1].Timestamp.Sub(start.Timestamp).Seconds()
+		if seconds <= 0 {
+			return 0, false
+		}
+		return increase / seconds, true
+	default:
+		return sampleValue(bucket[len(bucket)-1]), true
+	}
+}
+
+func sampleValue(s client.Sample) float64 {
+	if s.Value != nil {
+		return *s.Value
+	}
+	if s.Delta != nil {
+		return float64(*s.Delta)
+	}
+	return 0
+}
+
+// parseTime - parse RFC3339 time or unix timestamp in seconds.
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		sec, frac := math.Modf(seconds)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// parseStep - parse duration like "30s" or number of seconds.
+func parseStep(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// rawString - json value as string: "abc" -> abc, 123 -> 123, null -> "".
+func rawString(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
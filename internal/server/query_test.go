@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+var queryStart = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func gaugeSample(offset time.Duration, value float64) client.Sample {
+	return client.Sample{ID: "Alloc", MType: "gauge", Value: &value, Timestamp: queryStart.Add(offset)}
+}
+
+func counterSample(offset time.Duration, delta int64) client.Sample {
+	return client.Sample{ID: "PollCount", MType: "counter", Delta: &delta, Timestamp: queryStart.Add(offset)}
+}
+
+func TestRangeQueryAggregate(t *testing.T) {
+	gauges := []client.Sample{
+		gaugeSample(0, 1),
+		gaugeSample(20*time.Second, 5),
+		gaugeSample(40*time.Second, 3),
+		gaugeSample(70*time.Second, 10),
+		gaugeSample(190*time.Second, 2),
+	}
+	counters := []client.Sample{
+		counterSample(-10*time.Second, 0),
+		counterSample(30*time.Second, 20),
+		counterSample(50*time.Second, 40),
+		counterSample(90*time.Second, 10),
+	}
+	tests := []struct {
+		name    string
+		agg     string
+		samples []client.Sample
+		want    []Point
+	}{
+		{
+			name:    "[Positive] Минимум по шагам",
+			agg:     "min",
+			samples: gauges,
+			want:    []Point{{queryStart, 1}, {queryStart.Add(time.Minute), 10}, {queryStart.Add(3 * time.Minute), 2}},
+		},
+		{
+			name:    "[Positive] Максимум по шагам",
+			agg:     "max",
+			samples: gauges,
+			want:    []Point{{queryStart, 5}, {queryStart.Add(time.Minute), 10}, {queryStart.Add(3 * time.Minute), 2}},
+		},
+		{
+			name:    "[Positive] Среднее по шагам",
+			agg:     "avg",
+			samples: gauges,
+			want:    []Point{{queryStart, 3}, {queryStart.Add(time.Minute), 10}, {queryStart.Add(3 * time.Minute), 2}},
+		},
+		{
+			name:    "[Positive] Последнее значение по шагам",
+			agg:     "last",
+			samples: gauges,
+			want:    []Point{{queryStart, 3}, {queryStart.Add(time.Minute), 10}, {queryStart.Add(3 * time.Minute), 2}},
+		},
+		{
+			name:    "[Positive] Скорость счётчика со сбросом",
+			agg:     "rate",
+			samples: counters,
+			want:    []Point{{queryStart, 40.0 / 60}, {queryStart.Add(time.Minute), 10.0 / 40}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := RangeQuery{ID: "x", MType: "counter", Start: queryStart, End: queryStart.Add(5 * time.Minute), Step: time.Minute, Agg: tt.agg}
+			points := q.Aggregate(tt.samples)
+			require.Len(t, points, len(tt.want))
+			for i := range tt.want {
+				assert.True(t, tt.want[i].Timestamp.Equal(points[i].Timestamp))
+				assert.InDelta(t, tt.want[i].Value, points[i].Value, 1e-9)
+			}
+		})
+	}
+}
+
+func TestNewRangeQuery(t *testing.T) {
+	now := queryStart.Add(time.Hour)
+	q, err := NewRangeQuery("Alloc", "Gauge", "", "", "", "", now)
+	require.NoError(t, err)
+	assert.Equal(t, queryStart, q.Start)
+	assert.Equal(t, now, q.End)
+	assert.Equal(t, time.Minute, q.Step)
+	assert.Equal(t, "last", q.Agg)
+
+	q, err = NewRangeQuery("Alloc", "gauge", "1672531200", "2023-01-01T00:10:00Z", "30", "max", now)
+	require.NoError(t, err)
+	assert.True(t, queryStart.Equal(q.Start))
+	assert.Equal(t, 30*time.Second, q.Step)
+
+	_, err = NewRangeQuery("Alloc", "gauge", "", "", "", "rate", now)
+	assert.Error(t, err)
+	_, err = NewRangeQuery("Alloc", "gauge", "", "", "1ms", "avg", now)
+	assert.Error(t, err)
+	_, err = NewRangeQuery("Alloc", "histogram", "", "", "", "", now)
+	assert.Error(t, err)
+}
+
+func TestQueryRange(t *testing.T) {
+	storage := Storage{
+		Metrics: make(map[string]client.Metrics, 10),
+		History: NewHistory(10, 0),
+	}
+	for _, value := range []float64{1, 2, 3} {
+		v := value
+		storage.SaveGaugeMetric(&client.Metrics{ID: "Alloc", MType: "gauge", Value: &v})
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		code   int
+		value  float64
+	}{
+		{
+			name:   "[Positive] GET запрос с агрегацией max - получаю 200",
+			method: http.MethodGet,
+			url:    "/query_range?type=gauge&name=Alloc&agg=max&step=1h",
+			code:   http.StatusOK,
+			value:  3,
+		},
+		{
+			name:   "[Positive] POST запрос с агрегацией avg - получаю 200",
+			method: http.MethodPost,
+			url:    "/query_range/",
+			body:   `{"id":"Alloc","type":"gauge","agg":"avg","step":"1h"}`,
+			code:   http.StatusOK,
+			value:  2,
+		},
+		{
+			name:   "[Negative] Неизвестная метрика - получаю 404",
+			method: http.MethodGet,
+			url:    "/query_range?type=gauge&name=Sys",
+			code:   http.StatusNotFound,
+		},
+		{
+			name:   "[Negative] Неизвестная агрегация - получаю 400",
+			method: http.MethodGet,
+			url:    "/query_range?type=gauge&name=Alloc&agg=median",
+			code:   http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r := gin.New()
+			r.RedirectTrailingSlash = false
+			rg := NewRouterGroup(&r.RouterGroup, &storage, "")
+			rg.Routes()
+
+			r.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.code, res.StatusCode)
+			if tt.code != http.StatusOK {
+				return
+			}
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			var response RangeResponse
+			require.NoError(t, json.Unmarshal(body, &response))
+			require.Len(t, response.Points, 1)
+			assert.Equal(t, tt.value, response.Points[0].Value)
+		})
+	}
+}
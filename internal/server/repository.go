@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/db"
+	"github.com/iddanilov/metricsAndAlerting/internal/kv"
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+	"github.com/iddanilov/metricsAndAlerting/internal/sqlite"
+)
+
+//go:generate mockgen -destination=mocks/repository.go -package=mocks . Repository
+
+// ErrHistoryDisabled - storage doesn't keep history of metrics
+var ErrHistoryDisabled = errors.New("history is disabled")
+
+// Repository - storage of metrics used by handlers.
+// Implemented by in-memory Storage, embedded kv.DB, SQLite sqlite.DB and Postgres db.DB.
+type Repository interface {
+	// GetMetric - series of metric with the name and labels
+	GetMetric(ctx context.Context, id string, labels client.Labels) (client.Metrics, error)
+	// GetMetricByType - series of metric with the type, name and labels
+	GetMetricByType(ctx context.Context, mType, id string, labels client.Labels) (client.Metrics, error)
+	// GetSeries - all series of metric with the name
+	GetSeries(ctx context.Context, id string) ([]client.Metrics, error)
+	// GetMetrics - all series of all metrics
+	GetMetrics(ctx context.Context) ([]client.Metrics, error)
+	// UpdateMetric - save metric: counter is accumulated, gauge is replaced
+	UpdateMetric(ctx context.Context, m client.Metrics) error
+	// UpdateMetrics - save batch of metrics atomically
+	UpdateMetrics(ctx context.Context, metrics []client.Metrics) error
+	// GetHistory - saved values of series between from and to ordered by time
+	GetHistory(ctx context.Context, id string, labels client.Labels, from, to time.Time) ([]client.Sample, error)
+	// DeleteHistory - remove history saved before the time
+	DeleteHistory(ctx context.Context, before time.Time) (int64, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// StatsWriter - storage with internal statistics, for example connection pool of the database
+type StatsWriter interface {
+	// WriteStats - write statistics in Prometheus text exposition format
+	WriteStats(w io.Writer) error
+}
+
+var (
+	_ Repository = (*Storage)(nil)
+	_ Repository = (*db.DB)(nil)
+	_ Repository = (*kv.DB)(nil)
+	_ Repository = (*sqlite.DB)(nil)
+)
+
+var _ StatsWriter = (*db.DB)(nil)
+
+// NewRepository - create storage selected by config: SQLite if DSN is sqlite://path, Postgres if DSN is set,
+// embedded key-value storage if KVFile is set, otherwise in-memory storage with file.
+// Postgres is waited for until ctx is done.
+func NewRepository(ctx context.Context, cfg *Config) (Repository, error) {
+	if path, ok := sqlite.Path(cfg.DSN); ok {
+		storage, err := sqlite.NewDB(path)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	}
+	if cfg.DSN == "" && cfg.KVFile != "" {
+		storage, err := kv.NewDB(cfg.KVFile)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	}
+	if cfg.DSN == "" {
+		storage, err := NewStorages(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	}
+	storage, err := db.NewDB(cfg.DSN, cfg.DBOptions())
+	if err != nil {
+		return nil, err
+	}
+	// БД может быть недоступна несколько секунд при одновременном запуске
+	if err = storage.WaitReady(ctx); err != nil {
+		storage.Close()
+		return nil, err
+	}
+	if err = storage.CreateTable(ctx); err != nil {
+		storage.Close()
+		return nil, err
+	}
+	if err = storage.Warm(ctx); err != nil {
+		log.Println("Can't warm read cache", err)
+	}
+	return storage, nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+const (
+	snapshotFormat  = "metrics-snapshot"
+	snapshotVersion = 2
+)
+
+// SnapshotHeader - first line of snapshot file.
+// Files without header are snapshots of version 1 (only json map of metrics).
+type SnapshotHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Seq       uint64    `json:"seq"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Snapshot - metrics and sequence number of the last WAL record applied to them
+type Snapshot struct {
+	SnapshotHeader
+	Metrics map[string]client.Metrics
+}
+
+// ReadSnapshot - read snapshot from file. Missing or empty file is an empty snapshot.
+func ReadSnapshot(fileName string) (Snapshot, error) {
+	var snapshot Snapshot
+	file, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return snapshot, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	var first json.RawMessage
+	if err = decoder.Decode(&first); err != nil {
+		if err == io.EOF {
+			return snapshot, nil
+		}
+		return snapshot, err
+	}
+	if err = json.Unmarshal(first, &snapshot.SnapshotHeader); err == nil && snapshot.Format == snapshotFormat {
+		if snapshot.Version > snapshotVersion {
+			return snapshot, fmt.Errorf("snapshot %s: unsupported version %d", fileName, snapshot.Version)
+		}
+		err = decoder.Decode(&snapshot.Metrics)
+		return snapshot, err
+	}
+	// снимок первой версии без заголовка
+	snapshot.SnapshotHeader = SnapshotHeader{Version: 1}
+	err = json.Unmarshal(first, &snapshot.Metrics)
+	return snapshot, err
+}
+
+// WriteSnapshot - write snapshot in temp file and rename it to fileName.
+// If keep is greater than zero, previous snapshots are kept as fileName.1 ... fileName.keep.
+func WriteSnapshot(fileName string, snapshot Snapshot, keep int) error {
+	snapshot.Format = snapshotFormat
+	snapshot.Version = snapshotVersion
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	if err := encoder.Encode(snapshot.SnapshotHeader); err != nil {
+		return err
+	}
+	if err := encoder.Encode(snapshot.Metrics); err != nil {
+		return err
+	}
+	if keep > 0 {
+		if err := rotateSnapshots(fileName, keep); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(fileName, buf.Bytes())
+}
+
+// rotateSnapshots - shift fileName.i to fileName.i+1 and link current snapshot as fileName.1
+func rotateSnapshots(fileName string, keep int) error {
+	if _, err := os.Stat(fileName); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	for i := keep - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", fileName, i), fmt.Sprintf("%s.%d", fileName, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	previous := fileName + ".1"
+	if err := os.Remove(previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// текущий снимок остаётся на месте до rename нового
+	return os.Link(fileName, previous)
+}
+
+// writeFileAtomic - write data in temp file in the same directory, sync it and rename to fileName
+func writeFileAtomic(fileName string, data []byte) error {
+	dir := filepath.Dir(fileName)
+	tmp, err := os.CreateTemp(dir, filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), fileName); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir - sync directory, so rename survives crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "metrics.json")
+
+	snapshot, err := ReadSnapshot(fileName)
+	require.NoError(t, err)
+	assert.Nil(t, snapshot.Metrics, "missing file is empty snapshot")
+
+	value := 1.5
+	for i := 1; i <= 4; i++ {
+		delta := int64(i)
+		require.NoError(t, WriteSnapshot(fileName, Snapshot{
+			SnapshotHeader: SnapshotHeader{Seq: uint64(i)},
+			Metrics: map[string]client.Metrics{
+				"Alloc":     {ID: "Alloc", MType: "gauge", Value: &value},
+				"PollCount": {ID: "PollCount", MType: "counter", Delta: &delta},
+			},
+		}, 2))
+	}
+
+	snapshot, err = ReadSnapshot(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, snapshotVersion, snapshot.Version)
+	assert.Equal(t, uint64(4), snapshot.Seq)
+	assert.Equal(t, int64(4), *snapshot.Metrics["PollCount"].Delta)
+
+	// хранятся два предыдущих снимка
+	for i, seq := range []uint64{3, 2} {
+		previous, err := ReadSnapshot(fmt.Sprintf("%s.%d", fileName, i+1))
+		require.NoError(t, err)
+		assert.Equal(t, seq, previous.Seq)
+	}
+	_, err = os.Stat(fileName + ".3")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// временные файлы удалены
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSnapshotV1(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{"Alloc":{"id":"Alloc","type":"gauge","value":1.5}}`+"\n"), 0644))
+
+	snapshot, err := ReadSnapshot(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, 1, snapshot.Version)
+	assert.Zero(t, snapshot.Seq)
+	assert.Equal(t, 1.5, *snapshot.Metrics["Alloc"].Value)
+
+	require.NoError(t, os.WriteFile(fileName, []byte(`{"format":"metrics-snapshot","version":3}`+"\n{}\n"), 0644))
+	_, err = ReadSnapshot(fileName)
+	assert.Error(t, err)
+}
+
+func TestSnapshotBeforeCompact(t *testing.T) {
+	cfg := &Config{
+		StoreFile:     filepath.Join(t.TempDir(), "metrics.json"),
+		Restore:       true,
+		StoreWAL:      true,
+		StoreInterval: time.Hour,
+	}
+	storage, err := NewStorages(cfg)
+	require.NoError(t, err)
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 2)))
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 3)))
+
+	// сервер упал после записи снимка, но до очистки журнала
+	snapshot := Snapshot{SnapshotHeader: SnapshotHeader{Seq: storage.wal.Seq()}, Metrics: storage.Metrics}
+	require.NoError(t, WriteSnapshot(cfg.StoreFile, snapshot, 0))
+
+	storage, err = NewStorages(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *storage.Metrics["PollCount"].Delta, "counter must not be applied twice")
+	require.NoError(t, storage.Close())
+}
@@ -1,82 +1,331 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
 	client "github.com/iddanilov/metricsAndAlerting/internal/models"
 )
 
+// Storage - in-memory storage, metrics are saved by series key (name and labels)
 type Storage struct {
 	Metrics map[string]client.Metrics
 	mutex   sync.Mutex
 	File    string
+	History *History
+	wal     *WAL
+	// snapshotMutex - снимки пишутся по очереди, иначе старый снимок может заменить новый
+	snapshotMutex sync.Mutex
+	// keep - количество предыдущих снимков, которые сохраняются для отката
+	keep int
+	// version - номер последнего применённого обновления
+	version uint64
+	// commit - обновление возвращается после записи на диск: в WAL или в снимок в синхронном режиме
+	commit *groupCommit
 }
 
-func NewStorages(cfg *Config) *Storage {
+func NewStorages(cfg *Config) (*Storage, error) {
 	events := make(map[string]client.Metrics, 10)
+	var snapshot Snapshot
 	if cfg.Restore {
-		result, err := ReadEvents(cfg.StoreFile)
+		var err error
+		snapshot, err = ReadSnapshot(cfg.StoreFile)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		if result != nil {
-			events = result
+		if snapshot.Metrics != nil {
+			events = snapshot.Metrics
 		}
 	}
-	return &Storage{
+	var history *History
+	if cfg.HistorySize > 0 {
+		history = NewHistory(cfg.HistorySize, cfg.HistoryRetention)
+	}
+	storage := &Storage{
 		Metrics: events,
 		mutex:   sync.Mutex{},
 		File:    cfg.StoreFile,
+		History: history,
+		keep:    cfg.SnapshotKeep,
 	}
+	if cfg.StoreFile != "" && cfg.StoreInterval == 0 {
+		// синхронный режим: каждое обновление сохраняется в снимок, журнал не нужен
+		if err := storage.recoverWAL(cfg.StoreFile+".wal", snapshot.Seq, cfg.Restore); err != nil {
+			return nil, err
+		}
+		storage.commit = newGroupCommit(storage.snapshot)
+		log.Println("Synchronous store mode")
+	} else if cfg.StoreWAL && cfg.StoreFile != "" {
+		wal, err := OpenWAL(cfg.StoreFile + ".wal")
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Restore {
+			// в журнале обновления, принятые после последнего снимка
+			count, err := wal.Replay(snapshot.Seq, func(metrics []client.Metrics) {
+				for _, m := range metrics {
+					storage.apply(m, time.Time{})
+				}
+			})
+			if err != nil {
+				wal.Close()
+				return nil, err
+			}
+			log.Printf("Replayed %d WAL records", count)
+		} else if err = wal.Truncate(); err != nil {
+			wal.Close()
+			return nil, err
+		}
+		storage.wal = wal
+		storage.commit = newGroupCommit(storage.syncWAL)
+	} else if cfg.StoreFile != "" {
+		// снимки по интервалу без журнала: журнал прошлого запуска применяется и очищается
+		if err := storage.recoverWAL(cfg.StoreFile+".wal", snapshot.Seq, cfg.Restore); err != nil {
+			return nil, err
+		}
+	}
+	return storage, nil
 }
 
-func ReadEvents(fileName string) (metrics map[string]client.Metrics, err error) {
-	file, err := os.OpenFile(fileName, os.O_RDONLY|os.O_CREATE, 0777)
-	defer func(file *os.File) {
-		err = file.Close()
-	}(file)
+// recoverWAL - apply log left by WAL mode after the snapshot, save the result in snapshot and clear the log.
+// Modes without WAL don't write the log, so its records would be lost or replayed later on top of newer snapshots.
+func (s *Storage) recoverWAL(path string, after uint64, restore bool) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	wal, err := OpenWAL(path)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer wal.Close()
+	if !restore {
+		return wal.Truncate()
+	}
+	count, err := wal.Replay(after, func(metrics []client.Metrics) {
+		for _, m := range metrics {
+			s.apply(m, time.Time{})
+		}
+	})
+	if err != nil {
+		return err
 	}
-	err = json.NewDecoder(file).Decode(&metrics)
+	log.Printf("Replayed %d WAL records", count)
+	// снимок хранит номер последней записи: при сбое до очистки журнала записи не применятся повторно
+	s.wal = wal
+	_, err = s.snapshot()
+	s.wal = nil
+	if err != nil {
+		return err
+	}
+	return wal.Truncate()
+}
+
+// ReadEvents - metrics from snapshot file
+func ReadEvents(fileName string) (map[string]client.Metrics, error) {
+	snapshot, err := ReadSnapshot(fileName)
 	if err != nil {
 		return nil, err
 	}
-	return metrics, nil
+	return snapshot.Metrics, nil
 }
 
+// SaveMetricInFile - write snapshot of metrics in file and compact WAL.
+// The snapshot is a copy of metrics taken under the lock together with WAL sequence number,
+// it's written to temp file and renamed, so the file always contains a whole snapshot.
 func (s *Storage) SaveMetricInFile() error {
+	_, err := s.snapshot()
+	return err
+}
+
+// snapshot - write snapshot in file, returns version of the last update in it
+func (s *Storage) snapshot() (uint64, error) {
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	s.mutex.Lock()
+	version := s.version
 	if len(s.Metrics) == 0 {
+		s.mutex.Unlock()
+		return version, nil
+	}
+	snapshot := Snapshot{
+		SnapshotHeader: SnapshotHeader{CreatedAt: time.Now()},
+		Metrics:        make(map[string]client.Metrics, len(s.Metrics)),
+	}
+	for key, m := range s.Metrics {
+		snapshot.Metrics[key] = m
+	}
+	if s.wal != nil {
+		snapshot.Seq = s.wal.Seq()
+	}
+	s.mutex.Unlock()
+
+	if err := WriteSnapshot(s.File, snapshot, s.keep); err != nil {
+		return 0, err
+	}
+	if s.wal == nil {
+		return version, nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return version, s.wal.Compact(snapshot.Seq)
+}
+
+// syncWAL - sync records written in WAL, returns version of the last update in it
+func (s *Storage) syncWAL() (uint64, error) {
+	s.mutex.Lock()
+	version := s.version
+	s.mutex.Unlock()
+	return version, s.wal.Sync()
+}
+
+func (s *Storage) SaveGaugeMetric(metric *client.Metrics) error {
+	return s.SaveMetrics([]client.Metrics{*metric})
+}
+
+func (s *Storage) SaveCountMetric(metric client.Metrics) error {
+	return s.SaveMetrics([]client.Metrics{metric})
+}
+
+// SaveMetrics - save batch of validated metrics under one lock:
+// counters are accumulated, gauges are replaced.
+// The batch is written in WAL before it's applied. With WAL or in synchronous mode
+// the method returns when the batch is on disk, concurrent batches are flushed together.
+func (s *Storage) SaveMetrics(metrics []client.Metrics) error {
+	s.mutex.Lock()
+	if s.wal != nil {
+		if err := s.wal.Write(metrics); err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+	}
+	now := time.Now()
+	for _, metric := range metrics {
+		s.apply(metric, now)
+	}
+	s.version++
+	version := s.version
+	s.mutex.Unlock()
+
+	if s.commit == nil {
 		return nil
 	}
-	file, err := os.OpenFile(s.File, os.O_WRONLY|os.O_CREATE, 0777)
-	if err != nil {
-		return err
+	return s.commit.wait(version)
+}
+
+// apply - save metric in map and history, zero time means history isn't recorded
+func (s *Storage) apply(metric client.Metrics, now time.Time) {
+	key := metric.Key()
+	if strings.EqualFold(metric.MType, "counter") && metric.Delta != nil {
+		delta := *metric.Delta
+		if result := s.Metrics[key]; result.Delta != nil {
+			delta += *result.Delta
+		}
+		metric.Delta = &delta
 	}
+	s.Metrics[key] = metric
+	if s.History != nil && !now.IsZero() {
+		s.History.Record(metric, now)
+	}
+}
 
-	err = json.NewEncoder(file).Encode(s.Metrics)
-	if err != nil {
-		return err
+// GetMetrics - copy of all stored metrics.
+func (s *Storage) GetMetrics(ctx context.Context) ([]client.Metrics, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]client.Metrics, 0, len(s.Metrics))
+	for _, m := range s.Metrics {
+		result = append(result, m)
 	}
-	return err
+	return result, nil
 }
 
-func (s *Storage) SaveGaugeMetric(metric *client.Metrics) {
+// GetSeries - all series of metric with the name, sorted by key.
+func (s *Storage) GetSeries(ctx context.Context, id string) ([]client.Metrics, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.Metrics[metric.ID] = *metric
+	var result []client.Metrics
+	for _, m := range s.Metrics {
+		if m.ID == id {
+			result = append(result, m)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key() < result[j].Key() })
+	return result, nil
 }
 
-func (s *Storage) SaveCountMetric(metric client.Metrics) {
+// GetMetric - series of metric with the name and labels.
+func (s *Storage) GetMetric(ctx context.Context, id string, labels client.Labels) (client.Metrics, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	result := s.Metrics[metric.ID]
-	if result.Delta != nil {
-		*metric.Delta = *metric.Delta + *result.Delta
+	m, ok := s.Metrics[client.Metrics{ID: id, Labels: labels}.Key()]
+	if !ok {
+		return client.Metrics{}, middleware.ErrNotFound
 	}
-	s.Metrics[metric.ID] = metric
+	return m, nil
+}
 
+// GetMetricByType - series of metric with the type, name and labels.
+func (s *Storage) GetMetricByType(ctx context.Context, mType, id string, labels client.Labels) (client.Metrics, error) {
+	m, err := s.GetMetric(ctx, id, labels)
+	if err != nil {
+		return client.Metrics{}, err
+	}
+	if !strings.EqualFold(m.MType, mType) {
+		return client.Metrics{}, middleware.ErrNotFound
+	}
+	return m, nil
+}
+
+// UpdateMetric - save metric: counter is accumulated, gauge is replaced.
+func (s *Storage) UpdateMetric(ctx context.Context, m client.Metrics) error {
+	return s.SaveMetrics([]client.Metrics{m})
+}
+
+// UpdateMetrics - save batch of metrics under one lock.
+func (s *Storage) UpdateMetrics(ctx context.Context, metrics []client.Metrics) error {
+	return s.SaveMetrics(metrics)
+}
+
+// GetHistory - values of series between from and to ordered by time.
+func (s *Storage) GetHistory(ctx context.Context, id string, labels client.Labels, from, to time.Time) ([]client.Sample, error) {
+	if s.History == nil {
+		return nil, ErrHistoryDisabled
+	}
+	return s.History.Range(client.Metrics{ID: id, Labels: labels}.Key(), from, to), nil
+}
+
+// DeleteHistory - remove history recorded before the time.
+func (s *Storage) DeleteHistory(ctx context.Context, before time.Time) (int64, error) {
+	if s.History == nil {
+		return 0, nil
+	}
+	return s.History.DropBefore(before), nil
+}
+
+// Ping - in-memory storage is always available.
+func (s *Storage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close - save metrics in file and close WAL before exit.
+func (s *Storage) Close() error {
+	if s.File == "" {
+		return nil
+	}
+	if err := s.SaveMetricInFile(); err != nil {
+		return err
+	}
+	if s.wal != nil {
+		return s.wal.Close()
+	}
+	return nil
 }
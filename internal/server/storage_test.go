@@ -1,9 +1,13 @@
 package server
 
 import (
+	"context"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	client "github.com/iddanilov/metricsAndAlerting/internal/models"
 )
@@ -31,7 +35,9 @@ func TestSaveGaugeMetric(t *testing.T) {
 		// запускаем каждый тест
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := NewConfig()
-			storage := NewStorages(cfg)
+			cfg.StoreFile = filepath.Join(t.TempDir(), "metrics.json")
+			storage, err := NewStorages(cfg)
+			require.NoError(t, err)
 			storage.SaveGaugeMetric(&tt.gaugeMetricResult)
 
 			assert.Equal(t, tt.gaugeMetricResult, storage.Metrics[tt.gaugeMetricResult.ID])
@@ -60,7 +66,9 @@ func TestSaveCounterMetric(t *testing.T) {
 		// запускаем каждый тест
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := NewConfig()
-			storage := NewStorages(cfg)
+			cfg.StoreFile = filepath.Join(t.TempDir(), "metrics.json")
+			storage, err := NewStorages(cfg)
+			require.NoError(t, err)
 			storage.SaveCountMetric(tt.countMetricResult)
 
 			assert.Equal(t, tt.countMetricResult, storage.Metrics[tt.countMetricResult.ID])
@@ -68,3 +76,42 @@ func TestSaveCounterMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestStorageRepository(t *testing.T) {
+	ctx := context.Background()
+	storage := &Storage{
+		Metrics: make(map[string]client.Metrics, 10),
+		History: NewHistory(10, 0),
+	}
+	delta := int64(2)
+	labels := client.Labels{"host": "h1"}
+	require.NoError(t, storage.UpdateMetrics(ctx, []client.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &floatValue, Labels: labels},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}))
+	require.NoError(t, storage.UpdateMetric(ctx, client.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}))
+
+	m, err := storage.GetMetricByType(ctx, "counter", "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *m.Delta)
+	assert.Equal(t, int64(2), delta, "delta of request must not be changed")
+
+	_, err = storage.GetMetricByType(ctx, "gauge", "PollCount", nil)
+	assert.Error(t, err)
+	_, err = storage.GetMetric(ctx, "Alloc", nil)
+	assert.Error(t, err)
+	m, err = storage.GetMetric(ctx, "Alloc", labels)
+	require.NoError(t, err)
+	assert.Equal(t, floatValue, *m.Value)
+
+	samples, err := storage.GetHistory(ctx, "PollCount", nil, time.Now().Add(-time.Minute), time.Now())
+	require.NoError(t, err)
+	assert.Len(t, samples, 2)
+	deleted, err := storage.DeleteHistory(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+
+	storage.History = nil
+	_, err = storage.GetHistory(ctx, "PollCount", nil, time.Now().Add(-time.Minute), time.Now())
+	assert.ErrorIs(t, err, ErrHistoryDisabled)
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// walHeaderSize - length, crc32 and sequence number before every record
+const walHeaderSize = 16
+
+// maxWALRecord - protection from reading garbage length of damaged record
+const maxWALRecord = 64 << 20
+
+var errTornRecord = errors.New("torn wal record")
+
+// WAL - append-only write-ahead log of the file storage.
+// Every accepted update is written and synced before the response,
+// the log is replayed on start after the snapshot and compacted after each successful snapshot.
+// Record is a batch of metrics: length of payload (4 bytes), crc32 of sequence and payload (4 bytes),
+// sequence number (8 bytes), json payload. Snapshot keeps sequence number of the last applied record,
+// so records which are already in the snapshot are skipped on replay.
+type WAL struct {
+	// mutex - защищает файл, Sync вызывается без блокировки хранилища
+	mutex sync.Mutex
+	file  *os.File
+	path  string
+	seq   uint64
+}
+
+// OpenWAL - open or create write-ahead log
+func OpenWAL(path string) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{file: file, path: path}, nil
+}
+
+// Append - write batch of metrics as one record and sync it to disk
+func (w *WAL) Append(metrics []client.Metrics) error {
+	if err := w.Write(metrics); err != nil {
+		return err
+	}
+	return w.Sync()
+}
+
+// Write - write batch of metrics as one record without sync, see Sync
+func (w *WAL) Write(metrics []client.Metrics) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	payload, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint64(record[8:], w.seq+1)
+	copy(record[walHeaderSize:], payload)
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(record[8:]))
+	if _, err = w.file.Write(record); err != nil {
+		return err
+	}
+	w.seq++
+	return nil
+}
+
+// Sync - sync written records to disk
+func (w *WAL) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.file.Sync()
+}
+
+// Seq - sequence number of the last record
+func (w *WAL) Seq() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.seq
+}
+
+// Replay - call apply for every record of the log with sequence number greater than after.
+// Log is truncated after the last whole record, so a record torn by crash is dropped.
+// Returns number of applied records.
+func (w *WAL) Replay(after uint64, apply func(metrics []client.Metrics)) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	w.seq = after
+	reader := bufio.NewReader(w.file)
+	var offset int64
+	var count int
+	for {
+		seq, metrics, size, err := readWALRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("WAL %s: drop records after offset %d: %v", w.path, offset, err)
+			if err = w.file.Truncate(offset); err != nil {
+				return count, err
+			}
+			break
+		}
+		offset += size
+		if seq <= after {
+			continue
+		}
+		apply(metrics)
+		w.seq = seq
+		count++
+	}
+	// следующие записи добавляются после последней целой записи
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+func readWALRecord(reader io.Reader) (uint64, []client.Metrics, int64, error) {
+	header := make([]byte, walHeaderSize)
+	n, err := io.ReadFull(reader, header)
+	if err == io.EOF {
+		return 0, nil, 0, io.EOF
+	}
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("%w: header %d bytes", errTornRecord, n)
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > maxWALRecord {
+		return 0, nil, 0, fmt.Errorf("%w: length %d", errTornRecord, length)
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		return 0, nil, 0, fmt.Errorf("%w: payload: %v", errTornRecord, err)
+	}
+	checksum := crc32.Update(crc32.ChecksumIEEE(header[8:]), crc32.IEEETable, payload)
+	if checksum != binary.BigEndian.Uint32(header[4:]) {
+		return 0, nil, 0, fmt.Errorf("%w: checksum mismatch", errTornRecord)
+	}
+	var metrics []client.Metrics
+	if err = json.Unmarshal(payload, &metrics); err != nil {
+		return 0, nil, 0, fmt.Errorf("%w: %v", errTornRecord, err)
+	}
+	return binary.BigEndian.Uint64(header[8:]), metrics, int64(walHeaderSize + length), nil
+}
+
+// Compact - remove records with sequence number up to seq, which are saved in snapshot.
+// Records appended after the snapshot was taken are kept.
+func (w *WAL) Compact(seq uint64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if seq >= w.seq {
+		return w.truncate()
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(w.file)
+	var offset int64
+	for {
+		recordSeq, _, size, err := readWALRecord(reader)
+		if err != nil {
+			return err
+		}
+		if recordSeq > seq {
+			break
+		}
+		offset += size
+	}
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tail, err := io.ReadAll(w.file)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(w.path, tail); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return err
+	}
+	w.file.Close()
+	w.file = file
+	return nil
+}
+
+// Truncate - remove all records
+func (w *WAL) Truncate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.truncate()
+}
+
+func (w *WAL) truncate() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close - close log file
+func (w *WAL) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	client "github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func walBatch(id string, delta int64) []client.Metrics {
+	return []client.Metrics{{ID: id, MType: "counter", Delta: &delta}}
+}
+
+func replayAll(t *testing.T, path string) ([][]client.Metrics, *WAL) {
+	wal, err := OpenWAL(path)
+	require.NoError(t, err)
+	var records [][]client.Metrics
+	count, err := wal.Replay(0, func(metrics []client.Metrics) {
+		records = append(records, metrics)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(records), count)
+	return records, wal
+}
+
+func TestWALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json.wal")
+	wal, err := OpenWAL(path)
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, wal.Append(walBatch("PollCount", i)))
+	}
+	require.NoError(t, wal.Close())
+
+	records, wal := replayAll(t, path)
+	defer wal.Close()
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, int64(3), *records[2][0].Delta)
+	}
+
+	// после replay новые записи добавляются в конец журнала
+	require.NoError(t, wal.Append(walBatch("PollCount", 4)))
+	require.NoError(t, wal.Truncate())
+	require.NoError(t, wal.Append(walBatch("PollCount", 5)))
+	records, wal = replayAll(t, path)
+	defer wal.Close()
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, int64(5), *records[0][0].Delta)
+	}
+}
+
+func TestWALCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json.wal")
+	wal, err := OpenWAL(path)
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, wal.Append(walBatch("PollCount", i)))
+	}
+	// записи после снимка остаются в журнале
+	require.NoError(t, wal.Compact(2))
+	require.NoError(t, wal.Append(walBatch("PollCount", 4)))
+	assert.Equal(t, uint64(4), wal.Seq())
+	require.NoError(t, wal.Close())
+
+	records, wal := replayAll(t, path)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, int64(3), *records[0][0].Delta)
+		assert.Equal(t, int64(4), *records[1][0].Delta)
+	}
+	assert.Equal(t, uint64(4), wal.Seq())
+
+	// записи, которые уже есть в снимке, пропускаются
+	var deltas []int64
+	count, err := wal.Replay(3, func(metrics []client.Metrics) {
+		deltas = append(deltas, *metrics[0].Delta)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []int64{4}, deltas)
+	require.NoError(t, wal.Close())
+}
+
+func TestWALTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.json.wal")
+	wal, err := OpenWAL(path)
+	require.NoError(t, err)
+	require.NoError(t, wal.Append(walBatch("PollCount", 1)))
+	require.NoError(t, wal.Append(walBatch("PollCount", 2)))
+	require.NoError(t, wal.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	// обе записи одной длины
+	first := len(data) / 2
+
+	// обрезаем последнюю запись на каждом байте: заголовок, длина, payload
+	for size := first + 1; size < len(data); size++ {
+		torn := filepath.Join(dir, "torn.wal")
+		require.NoError(t, os.WriteFile(torn, data[:size], 0644))
+
+		records, wal := replayAll(t, torn)
+		assert.Len(t, records, 1, "size %d", size)
+		info, err := os.Stat(torn)
+		require.NoError(t, err)
+		assert.Equal(t, int64(first), info.Size(), "torn record must be truncated, size %d", size)
+
+		require.NoError(t, wal.Append(walBatch("PollCount", 3)))
+		require.NoError(t, wal.Close())
+		records, wal = replayAll(t, torn)
+		assert.Len(t, records, 2, "size %d", size)
+		require.NoError(t, wal.Close())
+	}
+
+	// испорченная контрольная сумма
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-2] ^= 0xff
+	torn := filepath.Join(dir, "corrupted.wal")
+	require.NoError(t, os.WriteFile(torn, corrupted, 0644))
+	records, wal := replayAll(t, torn)
+	defer wal.Close()
+	assert.Len(t, records, 1)
+}
+
+// TestWALKillHelper - process which writes WAL until it is killed, started by TestWALKill
+func TestWALKillHelper(t *testing.T) {
+	path := os.Getenv("WAL_KILL_HELPER")
+	if path == "" {
+		t.Skip("helper process")
+	}
+	wal, err := OpenWAL(path)
+	require.NoError(t, err)
+	for {
+		require.NoError(t, wal.Append(walBatch("PollCount", 1)))
+	}
+}
+
+func TestWALKill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts helper process")
+	}
+	path := filepath.Join(t.TempDir(), "metrics.json.wal")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWALKillHelper$")
+	cmd.Env = append(os.Environ(), "WAL_KILL_HELPER="+path)
+	require.NoError(t, cmd.Start())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if info, err := os.Stat(path); err == nil && info.Size() > 4096 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, cmd.Process.Kill())
+	_ = cmd.Wait()
+
+	storage := &Storage{Metrics: make(map[string]client.Metrics)}
+	records, wal := replayAll(t, path)
+	defer wal.Close()
+	require.NotEmpty(t, records)
+	for _, metrics := range records {
+		for _, m := range metrics {
+			storage.apply(m, time.Time{})
+		}
+	}
+	assert.Equal(t, int64(len(records)), *storage.Metrics["PollCount"].Delta)
+}
+
+func TestStorageWALRecovery(t *testing.T) {
+	cfg := &Config{
+		StoreFile:     filepath.Join(t.TempDir(), "metrics.json"),
+		Restore:       true,
+		StoreWAL:      true,
+		StoreInterval: time.Hour,
+	}
+	value := 1.5
+	storage, err := NewStorages(cfg)
+	require.NoError(t, err)
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 2)))
+	require.NoError(t, storage.SaveMetrics([]client.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}))
+
+	// сервер упал до снимка - метрики восстанавливаются из журнала
+	storage, err = NewStorages(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *storage.Metrics["PollCount"].Delta)
+	assert.Equal(t, value, *storage.Metrics["Alloc"].Value)
+
+	require.NoError(t, storage.SaveMetricInFile())
+	info, err := os.Stat(cfg.StoreFile + ".wal")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "WAL must be truncated after snapshot")
+	require.NoError(t, storage.SaveMetrics(walBatch("PollCount", 3)))
+
+	// снимок и записи журнала после него
+	storage, err = NewStorages(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *storage.Metrics["PollCount"].Delta)
+	assert.Equal(t, value, *storage.Metrics["Alloc"].Value)
+	require.NoError(t, storage.Close())
+}
@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+// UpdateMetric - save metric, counter is accumulated, gauge is replaced.
+func (db *DB) UpdateMetric(ctx context.Context, m models.Metrics) error {
+	return db.UpdateMetrics(ctx, []models.Metrics{m})
+}
+
+// UpdateMetrics - save batch of metrics and their history in one transaction.
+// Metric with a name already saved with another type is rejected with middleware.ErrConflict.
+func (db *DB) UpdateMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if db.DB == nil {
+		return errors.New("you haven`t opened the storage")
+	}
+	if err := db.updateMetrics(ctx, metrics); err != nil {
+		log.Println("Can't update metrics", err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) updateMetrics(ctx context.Context, metrics []models.Metrics) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// после Commit откат ничего не делает
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println("Can't rollback tx", err)
+		}
+	}()
+
+	now := time.Now().UnixNano()
+	for _, m := range metrics {
+		mType := strings.ToLower(m.MType)
+		if err = registerType(ctx, tx, m.ID, mType); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, queryUpdateMetric, m.ID, mType, m.Delta, m.Value, m.Labels); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, queryInsertHistory, now, mType, m.ID, m.Labels); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// registerType - save type of new metric name, name saved with another type is a conflict
+func registerType(ctx context.Context, tx *sql.Tx, id, mType string) error {
+	if _, err := tx.ExecContext(ctx, queryInsertMetricType, id, mType); err != nil {
+		return err
+	}
+	var saved string
+	if err := tx.QueryRowContext(ctx, queryGetMetricType, id).Scan(&saved); err != nil {
+		return err
+	}
+	if saved != mType {
+		return fmt.Errorf("%w: metric %s is %s, not %s", middleware.ErrConflict, id, saved, mType)
+	}
+	return nil
+}
+
+// GetMetric - series of metric with the name and labels.
+func (db *DB) GetMetric(ctx context.Context, metricID string, labels models.Labels) (models.Metrics, error) {
+	return db.queryMetric(ctx, queryGetMetric, metricID, labels)
+}
+
+// GetMetricByType - series of metric with the type, name and labels.
+func (db *DB) GetMetricByType(ctx context.Context, mType, metricID string, labels models.Labels) (models.Metrics, error) {
+	return db.queryMetric(ctx, queryGetMetricByType, mType, metricID, labels)
+}
+
+func (db *DB) queryMetric(ctx context.Context, query string, args ...interface{}) (models.Metrics, error) {
+	var m models.Metrics
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	if err := row.Scan(&m.ID, &m.MType, &m.Delta, &m.Value, &m.Labels); err != nil {
+		return models.Metrics{}, err
+	}
+	return m, nil
+}
+
+// GetSeries - all series of metric with the name.
+func (db *DB) GetSeries(ctx context.Context, metricID string) ([]models.Metrics, error) {
+	return db.queryMetrics(ctx, queryGetSeries, metricID)
+}
+
+// GetMetrics - all series of all metrics.
+func (db *DB) GetMetrics(ctx context.Context) ([]models.Metrics, error) {
+	return db.queryMetrics(ctx, queryGetMetrics)
+}
+
+func (db *DB) queryMetrics(ctx context.Context, query string, args ...interface{}) ([]models.Metrics, error) {
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.Metrics
+	for rows.Next() {
+		var m models.Metrics
+		if err = rows.Scan(&m.ID, &m.MType, &m.Delta, &m.Value, &m.Labels); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// GetMetricNames - names of saved metrics.
+func (db *DB) GetMetricNames(ctx context.Context) ([]string, error) {
+	rows, err := db.DB.QueryContext(ctx, queryGetMetricNames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var v string
+		if err = rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, rows.Err()
+}
+
+// GetHistory - saved values of series between from and to ordered by time.
+func (db *DB) GetHistory(ctx context.Context, metricID string, labels models.Labels, from, to time.Time) ([]models.Sample, error) {
+	rows, err := db.DB.QueryContext(ctx, queryGetHistory, metricID, labels, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.Sample
+	for rows.Next() {
+		var sample models.Sample
+		var createdAt int64
+		if err = rows.Scan(&sample.ID, &sample.MType, &sample.Delta, &sample.Value, &sample.Labels, &createdAt); err != nil {
+			return nil, err
+		}
+		sample.Timestamp = time.Unix(0, createdAt)
+		result = append(result, sample)
+	}
+	return result, rows.Err()
+}
+
+// DeleteHistory - remove history saved before the time. Returns number of removed rows.
+func (db *DB) DeleteHistory(ctx context.Context, before time.Time) (int64, error) {
+	result, err := db.DB.ExecContext(ctx, queryDeleteHistory, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,148 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iddanilov/metricsAndAlerting/internal/middleware"
+	"github.com/iddanilov/metricsAndAlerting/internal/models"
+)
+
+func TestPath(t *testing.T) {
+	path, ok := Path("sqlite:///var/lib/metrics.db")
+	assert.True(t, ok)
+	assert.Equal(t, "/var/lib/metrics.db", path)
+	_, ok = Path("postgres://localhost:5432/metrics")
+	assert.False(t, ok)
+}
+
+func TestUpdateMetrics(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	db, err := NewDB(path)
+	require.NoError(t, err)
+
+	value := 1.5
+	delta := int64(2)
+	labels := models.Labels{"host": "h1"}
+	require.NoError(t, db.UpdateMetrics(ctx, []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "Alloc", MType: "gauge", Value: &value, Labels: labels},
+		{ID: "AllocX", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "Counter", Delta: &delta},
+	}))
+	require.NoError(t, db.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}))
+	newValue := 2.5
+	require.NoError(t, db.UpdateMetric(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: &newValue}))
+
+	// данные сохранены в файле и доступны после переоткрытия
+	require.NoError(t, db.Close())
+	db, err = NewDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Ping(ctx))
+
+	m, err := db.GetMetricByType(ctx, "counter", "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *m.Delta, "counter is accumulated")
+	m, err = db.GetMetric(ctx, "Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, *m.Value, "gauge is replaced")
+	m, err = db.GetMetric(ctx, "Alloc", labels)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, *m.Value)
+	assert.Equal(t, labels, m.Labels)
+	_, err = db.GetMetricByType(ctx, "gauge", "PollCount", nil)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = db.GetMetric(ctx, "Unknown", nil)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	series, err := db.GetSeries(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Len(t, series, 2)
+	metrics, err := db.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 4)
+	names, err := db.GetMetricNames(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Alloc", "AllocX", "PollCount"}, names)
+}
+
+func TestUpdateMetricsConflict(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewDB(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	value := 1.5
+	delta := int64(2)
+	require.NoError(t, db.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}))
+
+	tests := []struct {
+		name    string
+		metrics []models.Metrics
+	}{
+		{
+			name:    "[Negative] Имя сохранено с другим типом",
+			metrics: []models.Metrics{{ID: "PollCount", MType: "gauge", Value: &value}},
+		},
+		{
+			name: "[Negative] Имя отправлено с двумя типами в одном batch",
+			metrics: []models.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: &value},
+				{ID: "Alloc", MType: "counter", Delta: &delta},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.UpdateMetrics(ctx, tt.metrics)
+			assert.ErrorIs(t, err, middleware.ErrConflict)
+		})
+	}
+
+	// batch с конфликтом не сохраняется целиком
+	_, err = db.GetMetric(ctx, "Alloc", nil)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	m, err := db.GetMetric(ctx, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "counter", m.MType)
+	assert.Equal(t, int64(2), *m.Delta)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewDB(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	delta := int64(1)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}))
+	}
+	now := time.Now()
+
+	samples, err := db.GetHistory(ctx, "PollCount", nil, now.Add(-time.Minute), now)
+	require.NoError(t, err)
+	if assert.Len(t, samples, 3) {
+		// в истории хранится накопленное значение счётчика
+		assert.Equal(t, int64(1), *samples[0].Delta)
+		assert.Equal(t, int64(3), *samples[2].Delta)
+	}
+	samples, err = db.GetHistory(ctx, "PollCount", nil, now.Add(time.Second), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	deleted, err := db.DeleteHistory(ctx, now.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+	samples, err = db.GetHistory(ctx, "PollCount", nil, now.Add(-time.Minute), now)
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
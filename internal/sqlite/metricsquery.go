@@ -0,0 +1,74 @@
+package sqlite
+
+const (
+	// схема соответствует последней миграции Postgres, created_at хранится в наносекундах Unix
+	createTables = `
+CREATE TABLE IF NOT EXISTS metrics (
+	id TEXT NOT NULL,
+	m_type TEXT NOT NULL,
+	delta INTEGER,
+	value REAL,
+	labels TEXT NOT NULL DEFAULT '{}',
+	PRIMARY KEY (m_type, id, labels));
+CREATE TABLE IF NOT EXISTS metric_types (
+	id TEXT PRIMARY KEY,
+	m_type TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS metrics_history (
+	id TEXT NOT NULL,
+	m_type TEXT NOT NULL,
+	delta INTEGER,
+	value REAL,
+	labels TEXT NOT NULL DEFAULT '{}',
+	created_at INTEGER NOT NULL);
+CREATE INDEX IF NOT EXISTS metrics_history_id_labels_created_at_idx ON metrics_history (id, labels, created_at);
+CREATE INDEX IF NOT EXISTS metrics_history_created_at_idx ON metrics_history (created_at);
+`
+
+	queryGetMetricNames = `
+SELECT DISTINCT id FROM metrics
+`
+
+	queryGetMetric = `
+SELECT id, m_type, delta, value, labels FROM metrics WHERE id = ? AND labels = ? ORDER BY m_type LIMIT 1
+`
+	queryGetMetricByType = `
+SELECT id, m_type, delta, value, labels FROM metrics WHERE m_type = lower(?) AND id = ? AND labels = ?
+`
+	queryGetSeries = `
+SELECT id, m_type, delta, value, labels FROM metrics WHERE id = ?
+`
+	queryGetMetrics = `
+SELECT id, m_type, delta, value, labels FROM metrics
+`
+
+	queryInsertMetricType = `
+INSERT INTO metric_types(id, m_type) VALUES (?, ?) ON CONFLICT (id) DO NOTHING
+`
+	queryGetMetricType = `
+SELECT m_type FROM metric_types WHERE id = ?
+`
+
+	queryUpdateMetric = `
+INSERT INTO metrics(id, m_type, delta, value, labels)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (m_type, id, labels) DO
+UPDATE SET
+	delta=metrics.delta+excluded.delta,
+	value=excluded.value
+`
+	// в историю попадает значение после обновления, для счётчика - накопленное
+	queryInsertHistory = `
+INSERT INTO metrics_history(id, m_type, delta, value, labels, created_at)
+SELECT id, m_type, delta, value, labels, ? FROM metrics WHERE m_type = ? AND id = ? AND labels = ?
+`
+
+	queryGetHistory = `
+SELECT id, m_type, delta, value, labels, created_at FROM metrics_history
+WHERE id = ? AND labels = ? AND created_at BETWEEN ? AND ?
+ORDER BY created_at, rowid
+`
+
+	queryDeleteHistory = `
+DELETE FROM metrics_history WHERE created_at < ?
+`
+)
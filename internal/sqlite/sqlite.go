@@ -0,0 +1,66 @@
+// Package sqlite is a relational storage of metrics in a single SQLite file, it needs no database server.
+// Semantics of updates are the same as in Postgres storage: counter is accumulated, gauge is replaced,
+// name of metric keeps its first type.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+
+	// драйвер на чистом Go, cgo не нужен
+	_ "modernc.org/sqlite"
+)
+
+// Scheme - prefix of DSN which selects SQLite storage: sqlite://path/to/metrics.db
+const Scheme = "sqlite://"
+
+// Path - file of storage from DSN, false if DSN isn't an SQLite one
+func Path(dsn string) (string, bool) {
+	if !strings.HasPrefix(dsn, Scheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(dsn, Scheme), true
+}
+
+type DB struct {
+	DB *sql.DB
+}
+
+// NewDB - open or create storage file and its schema
+func NewDB(path string) (*DB, error) {
+	if path == "" {
+		return nil, errors.New("path of SQLite storage is empty")
+	}
+	// WAL не блокирует чтение во время записи, busy_timeout ждёт блокировку другого процесса
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(FULL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite допускает одного писателя: одно соединение исключает SQLITE_BUSY внутри процесса
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(createTables); err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Println("SQLite storage opened", path)
+
+	return &DB{DB: db}, nil
+}
+
+func (db *DB) Ping(ctx context.Context) error {
+	if db.DB == nil {
+		return errors.New("you haven`t opened the storage")
+	}
+	return db.DB.PingContext(ctx)
+}
+
+// Close - close storage file
+func (db *DB) Close() error {
+	if db.DB == nil {
+		return nil
+	}
+	return db.DB.Close()
+}